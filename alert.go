@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// alertPayload is the JSON body POSTed to -alert-webhook. Text makes it
+// usable as is with Slack and compatible incoming webhooks.
+type alertPayload struct {
+	Text          string `json:"text"`
+	State         string `json:"state"`
+	Host          string `json:"host,omitempty"`
+	Time          string `json:"time"`
+	LastSuccess   string `json:"last_success,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorTime string `json:"last_error_time,omitempty"`
+}
+
+// watchStalls POSTs to url when no bulk request has succeeded for longer
+// than after, and again once one does. State changes within debounce of
+// the last alert are held back, and only the latest state is sent when it
+// expires, so a flapping cluster produces one alert per debounce rather
+// than one per flap. It returns on shutdown.
+func watchStalls(url string, after, debounce time.Duration) {
+	start := time.Now()
+	interval := after / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	sent := "ok"
+	var sentAt time.Time
+	for !sleepOrShutdown(interval) {
+		last := start
+		if t := atomic.LoadInt64(&lastSuccess); t > 0 {
+			last = time.Unix(0, t)
+		}
+
+		state := "ok"
+		if time.Since(last) > after {
+			state = "stalled"
+		}
+		if state == sent || time.Since(sentAt) < debounce {
+			continue
+		}
+
+		if err := postAlert(url, state, last); err != nil {
+			log.Printf("Error sending %s alert: %v\n", state, err)
+			continue
+		}
+		sent, sentAt = state, time.Now()
+	}
+}
+
+// postAlert sends one alert for state, given when a bulk request last
+// succeeded.
+func postAlert(url, state string, last time.Time) error {
+	host, _ := os.Hostname()
+	p := alertPayload{
+		State: state,
+		Host:  host,
+		Time:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if atomic.LoadInt64(&lastSuccess) > 0 {
+		p.LastSuccess = last.UTC().Format(time.RFC3339)
+	}
+	if reason, t := lastFlushError(); reason != "" {
+		p.LastError = reason
+		p.LastErrorTime = t.UTC().Format(time.RFC3339)
+	}
+
+	if state == "stalled" {
+		p.Text = fmt.Sprintf("hpfeeds-elastic on %s: nothing indexed for %s", host, time.Since(last).Round(time.Second))
+		if p.LastError != "" {
+			p.Text += ", last error: " + p.LastError
+		}
+	} else {
+		state = "recovered"
+		p.State = state
+		p.Text = fmt.Sprintf("hpfeeds-elastic on %s: indexing again", host)
+	}
+
+	buf, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Post(url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+	log.Printf("Sent %s alert to webhook\n", state)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// appAliases maps lowercased app names to the app they're indexed as, so
+// e.g. kippo documents go into the cowrie index.
+type appAliases map[string]string
+
+// parseAppAliases builds the aliases from the -app-alias list of from=to
+// pairs, followed by those from -app-alias-file, a JSON object of from to
+// to. Names are lowercased, and the file overrides the list.
+func parseAppAliases(list, path string) (appAliases, error) {
+	a := appAliases{}
+	for _, pair := range strings.Split(list, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		from, to, ok := splitKeyValue(pair)
+		if !ok || to == "" {
+			return nil, fmt.Errorf("invalid alias %q, want from=to", pair)
+		}
+		a[strings.ToLower(from)] = strings.ToLower(to)
+	}
+
+	if path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var file map[string]string
+		if err := json.Unmarshal(buf, &file); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for from, to := range file {
+			if to == "" {
+				return nil, fmt.Errorf("%s: empty app to alias %q to", path, from)
+			}
+			a[strings.ToLower(from)] = strings.ToLower(to)
+		}
+	}
+
+	for from, to := range a {
+		if _, chained := a[to]; chained {
+			return nil, fmt.Errorf("alias %s=%s points at another alias", from, to)
+		}
+	}
+	return a, nil
+}
+
+// loggedAliases holds the app names whose alias was already logged.
+var loggedAliases sync.Map
+
+// normalizeApp returns the name app is indexed under: lowercased, with any
+// alias applied, and stripped of characters ES doesn't allow in index
+// names. Apps left with no usable name fall back to MissingField, the same
+// as missing fields in -index-template. The first use of each alias is
+// logged.
+func normalizeApp(app string, aliases appAliases) string {
+	name := strings.ToLower(strings.TrimSpace(app))
+	if to, ok := aliases[name]; ok {
+		if _, logged := loggedAliases.LoadOrStore(name, true); !logged {
+			parseLog.Printf("Indexing app %q as %q\n", app, to)
+		}
+		appAliased.Add(name, 1)
+		name = to
+	}
+	if name = sanitizeIndexPart(name); name == "" {
+		return MissingField
+	}
+	return name
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// appRuleSet is what -transforms-file does to the documents of one app:
+// renames top level fields, then drops fields, then converts field values to
+// a mapping type. Paths in drop and coerce are dotted paths into nested
+// objects.
+type appRuleSet struct {
+	Rename map[string]string `json:"rename"`
+	Drop   []string          `json:"drop"`
+	Coerce map[string]string `json:"coerce"`
+
+	renames renamer
+	drop    fieldSet
+}
+
+// appRules maps app names to their rule set.
+type appRules map[string]*appRuleSet
+
+// coercibleTypes are the mapping types -transforms-file can coerce to, the
+// ones coerceValue knows.
+var coercibleTypes = map[string]bool{
+	"long": true, "integer": true, "short": true, "byte": true,
+	"double": true, "float": true, "half_float": true, "scaled_float": true,
+	"boolean": true, "date": true, "ip": true, "keyword": true, "text": true,
+}
+
+// loadAppRules reads and validates a -transforms-file, a JSON object of app
+// name to rule set, e.g.
+//
+//	{"cowrie": {"rename": {"src": "src_ip"}, "drop": ["raw"], "coerce": {"dest_port": "long"}}}
+func loadAppRules(path string) (appRules, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	var rules appRules
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for app, set := range rules {
+		if set == nil {
+			return nil, fmt.Errorf("%s: %s: empty rule set", path, app)
+		}
+		var froms []string
+		for from := range set.Rename {
+			froms = append(froms, from)
+		}
+		sort.Strings(froms)
+		for _, from := range froms {
+			if set.Rename[from] == "" {
+				return nil, fmt.Errorf("%s: %s: empty name to rename %q to", path, app, from)
+			}
+			set.renames = append(set.renames, renameRule{from, set.Rename[from]})
+		}
+		set.drop = fieldSet{}
+		for _, field := range set.Drop {
+			if field == "" {
+				return nil, fmt.Errorf("%s: %s: empty field to drop", path, app)
+			}
+			set.drop.add(field)
+		}
+		for field, t := range set.Coerce {
+			if !coercibleTypes[t] {
+				return nil, fmt.Errorf("%s: %s: can't coerce %s to unknown type %q", path, app, field, t)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// Apply runs the rule set for app, if any, on doc and reports whether it
+// changed anything: renamed, dropped or coerced a field.
+func (r appRules) Apply(app string, doc map[string]interface{}) bool {
+	set, ok := r[app]
+	if !ok {
+		return false
+	}
+	changed := set.renames.Apply(doc)
+	if len(set.drop) > 0 && dropFields(doc, set.drop) {
+		changed = true
+	}
+	if len(set.Coerce) > 0 && coerceDoc(doc, fieldTypes(set.Coerce), "") {
+		changed = true
+	}
+	return changed
+}
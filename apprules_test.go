@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sampleRules adapts two honeypots' schemas to the common one.
+const sampleRules = `{
+	"cowrie": {
+		"rename": {"src": "src_ip", "dst_port": "dest_port"},
+		"drop": ["raw", "session.log"],
+		"coerce": {"dest_port": "long", "session.duration": "double", "login": "boolean"}
+	},
+	"conpot": {"drop": ["data"]}
+}`
+
+func writeRules(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "transforms.json")
+	if err := ioutil.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAppRulesApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		app     string
+		in      string
+		want    map[string]interface{}
+		changed bool
+	}{
+		{"all rules", "cowrie",
+			`{"src": "192.0.2.1", "dst_port": "2222", "raw": "x", "login": "true", "session": {"log": "x", "duration": "1.5"}}`,
+			map[string]interface{}{"src_ip": "192.0.2.1", "dest_port": int64(2222), "login": true,
+				"session": map[string]interface{}{"duration": 1.5}}, true},
+		{"drop only", "cowrie", `{"src_ip": "192.0.2.1", "raw": "x"}`,
+			map[string]interface{}{"src_ip": "192.0.2.1"}, true},
+		{"coerce only", "cowrie", `{"dest_port": "22"}`,
+			map[string]interface{}{"dest_port": int64(22)}, true},
+		{"invalid value dropped", "cowrie", `{"dest_port": "ssh"}`,
+			map[string]interface{}{}, true},
+		{"nothing to do", "cowrie", `{"src_ip": "192.0.2.1"}`,
+			map[string]interface{}{"src_ip": "192.0.2.1"}, false},
+		{"other app's rules", "conpot", `{"src": "192.0.2.1", "data": "x"}`,
+			map[string]interface{}{"src": "192.0.2.1"}, true},
+		{"app without rules", "dionaea", `{"src": "192.0.2.1", "raw": "x"}`,
+			map[string]interface{}{"src": "192.0.2.1", "raw": "x"}, false},
+	}
+	if parseLog == nil {
+		parseLog = newSampledLogger(0, 0, 0)
+	}
+	rules, err := loadAppRules(writeRules(t, sampleRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		doc := mustDecode(t, tt.in)
+		changed := rules.Apply(tt.app, doc)
+		if changed != tt.changed {
+			t.Errorf("%s: changed = %v, want %v", tt.name, changed, tt.changed)
+		}
+		if !reflect.DeepEqual(doc, tt.want) {
+			t.Errorf("%s: got %v\nwant %v", tt.name, doc, tt.want)
+		}
+	}
+}
+
+func TestLoadAppRulesInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{"unknown key", `{"cowrie": {"renames": {"a": "b"}}}`, "unknown field"},
+		{"empty rename", `{"cowrie": {"rename": {"a": ""}}}`, `empty name to rename "a" to`},
+		{"empty drop", `{"cowrie": {"drop": [""]}}`, "empty field to drop"},
+		{"unknown type", `{"cowrie": {"coerce": {"a": "int"}}}`, `unknown type "int"`},
+		{"null rule set", `{"cowrie": null}`, "empty rule set"},
+		{"truncated", `{"cowrie": {`, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		_, err := loadAppRules(writeRules(t, tt.rules))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
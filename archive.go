@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ArchiveDayLayout names the per-day directories under -archive-dir.
+const ArchiveDayLayout = "2006-01-02"
+
+// archiveRecord is one raw payload waiting to be archived.
+type archiveRecord struct {
+	app     string
+	payload []byte
+	at      time.Time
+}
+
+// archiver appends every raw payload to NDJSON files under dir, one file per
+// app per day: <dir>/<day>/<app>.ndjson.gz with the default gzip codec, .zst
+// with zstd and no extension uncompressed. Payloads are queued and written in
+// batches by a background goroutine so ingest never waits on disk. Each batch
+// is appended to its file as a separate gzip member or zstd frame, which the
+// command line tools read back as one stream.
+type archiver struct {
+	dir       string
+	codec     string
+	retention time.Duration
+	in        chan archiveRecord
+	done      chan struct{}
+}
+
+// ArchiveQueueSize is how many payloads can wait for the archive writer
+// before new ones are dropped.
+const ArchiveQueueSize = 10000
+
+// ArchiveFlushInterval is how often queued payloads are written out.
+const ArchiveFlushInterval = 5 * time.Second
+
+func newArchiver(dir, codec string, retentionDays int) (*archiver, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	a := &archiver{
+		dir:       dir,
+		codec:     codec,
+		retention: time.Duration(retentionDays) * 24 * time.Hour,
+		in:        make(chan archiveRecord, ArchiveQueueSize),
+		done:      make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+// Add queues a payload for archiving. If the writer has fallen too far
+// behind the payload is dropped and counted rather than blocking ingest.
+func (a *archiver) Add(app string, payload []byte) {
+	if app = sanitizeIndexPart(app); app == "" {
+		app = MissingField
+	}
+	select {
+	case a.in <- archiveRecord{app, payload, time.Now().UTC()}:
+	default:
+		archiveDropped.Add(1)
+	}
+}
+
+// Close writes out anything still queued and stops the writer.
+func (a *archiver) Close() {
+	close(a.in)
+	<-a.done
+}
+
+func (a *archiver) run() {
+	defer close(a.done)
+
+	flush := time.NewTicker(ArchiveFlushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	a.prune()
+
+	batch := make(map[string]*bytes.Buffer) // File path -> pending lines.
+	for {
+		select {
+		case r, ok := <-a.in:
+			if !ok {
+				a.write(batch)
+				return
+			}
+			path := filepath.Join(a.dir, r.at.Format(ArchiveDayLayout), r.app+".ndjson"+compressionExt(a.codec))
+			buf, ok := batch[path]
+			if !ok {
+				buf = new(bytes.Buffer)
+				batch[path] = buf
+			}
+			// Keep one payload per line where we can.
+			if err := json.Compact(buf, r.payload); err != nil {
+				buf.Write(r.payload)
+			}
+			buf.WriteByte('\n')
+		case <-flush.C:
+			a.write(batch)
+			batch = make(map[string]*bytes.Buffer)
+		case <-prune.C:
+			a.prune()
+		}
+	}
+}
+
+// write appends each pending buffer to its archive file.
+func (a *archiver) write(batch map[string]*bytes.Buffer) {
+	for path, buf := range batch {
+		if err := appendCompressed(path, a.codec, buf.Bytes()); err != nil {
+			log.Printf("Error writing archive %s: %v\n", path, err)
+		}
+	}
+}
+
+func appendCompressed(path, codec string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw, err := newCompressWriter(f, codec)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := zw.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// prune removes day directories older than the retention period. A zero
+// retention keeps archives forever.
+func (a *archiver) prune() {
+	if a.retention <= 0 {
+		return
+	}
+	days, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		log.Printf("Error listing archive dir: %v\n", err)
+		return
+	}
+	cutoff := time.Now().UTC().Add(-a.retention)
+	for _, d := range days {
+		day, err := time.Parse(ArchiveDayLayout, d.Name())
+		if !d.IsDir() || err != nil || !day.Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(a.dir, d.Name())); err != nil {
+			log.Printf("Error removing old archive %s: %v\n", d.Name(), err)
+			continue
+		}
+		log.Printf("Removed archive %s past retention\n", d.Name())
+	}
+}
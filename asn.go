@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ASNCacheSize bounds the number of cached ASN lookups. The cache is simply
+// emptied when it fills up; attacking IPs repeat enough that it refills with
+// the busy ones quickly.
+const ASNCacheSize = 100000
+
+// asnReader is the part of *maxminddb.Reader the ASN enricher uses.
+type asnReader interface {
+	Lookup(ip net.IP, result interface{}) error
+	Close() error
+}
+
+// asnRecord is an entry of a GeoLite2-ASN database.
+type asnRecord struct {
+	Number uint   `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+// asnEnricher adds src_asn and src_as_org to documents with a public
+// src_ip, from a MaxMind ASN database. It implements Processor so it runs
+// in the same chain as external plugins.
+type asnEnricher struct {
+	mu    sync.Mutex
+	path  string
+	db    asnReader
+	cache map[string]asnRecord
+}
+
+// privateNets are the non-routable ranges never looked up.
+var privateNets = mustParseCIDRs(
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10",
+	"fc00::/7",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// publicIP reports whether ip is globally routable.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newASNEnricher opens the ASN database at path.
+func newASNEnricher(path string) (*asnEnricher, error) {
+	a := &asnEnricher{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reopens the database, e.g. after it was updated on disk, and
+// empties the cache. On error the open database is kept.
+func (a *asnEnricher) Reload() error {
+	db, err := maxminddb.Open(a.path)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	old := a.db
+	a.db = db
+	a.cache = make(map[string]asnRecord)
+	a.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	log.Printf("Loaded ASN database %s\n", a.path)
+	return nil
+}
+
+// lookup returns the ASN record for ip, from the cache if possible.
+func (a *asnEnricher) lookup(ip net.IP) (asnRecord, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := ip.String()
+	if rec, ok := a.cache[key]; ok {
+		return rec, nil
+	}
+	var rec asnRecord
+	if err := a.db.Lookup(ip, &rec); err != nil {
+		return rec, err
+	}
+	if len(a.cache) >= ASNCacheSize {
+		a.cache = make(map[string]asnRecord)
+	}
+	a.cache[key] = rec
+	return rec, nil
+}
+
+// Process adds src_asn and src_as_org when src_ip is a public address found
+// in the database.
+func (a *asnEnricher) Process(doc map[string]interface{}) (map[string]interface{}, error) {
+	s, ok := doc["src_ip"].(string)
+	if !ok {
+		return doc, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil || !publicIP(ip) {
+		return doc, nil
+	}
+	rec, err := a.lookup(ip)
+	if err != nil {
+		return doc, err
+	}
+	if rec.Number != 0 {
+		doc["src_asn"] = rec.Number
+		doc["src_as_org"] = rec.Org
+	}
+	return doc, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// fakeASNReader is an in-memory ASN database that counts its lookups.
+type fakeASNReader struct {
+	records map[string]asnRecord
+	lookups int
+}
+
+func (r *fakeASNReader) Lookup(ip net.IP, result interface{}) error {
+	r.lookups++
+	if ip.Equal(net.ParseIP("198.51.100.66")) {
+		return errors.New("corrupt record")
+	}
+	*result.(*asnRecord) = r.records[ip.String()]
+	return nil
+}
+
+func (r *fakeASNReader) Close() error { return nil }
+
+func TestASNEnricher(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  map[string]interface{}
+		want map[string]interface{}
+	}{
+		{"public", map[string]interface{}{"src_ip": "203.0.113.9"},
+			map[string]interface{}{"src_ip": "203.0.113.9", "src_asn": uint(64500), "src_as_org": "Example Net"}},
+		{"ipv6", map[string]interface{}{"src_ip": "2001:db8::1"},
+			map[string]interface{}{"src_ip": "2001:db8::1", "src_asn": uint(64501), "src_as_org": "Example v6"}},
+		{"not in database", map[string]interface{}{"src_ip": "203.0.113.10"},
+			map[string]interface{}{"src_ip": "203.0.113.10"}},
+		{"private", map[string]interface{}{"src_ip": "192.168.1.1"},
+			map[string]interface{}{"src_ip": "192.168.1.1"}},
+		{"loopback", map[string]interface{}{"src_ip": "127.0.0.1"},
+			map[string]interface{}{"src_ip": "127.0.0.1"}},
+		{"cgnat", map[string]interface{}{"src_ip": "100.64.0.1"},
+			map[string]interface{}{"src_ip": "100.64.0.1"}},
+		{"unique local", map[string]interface{}{"src_ip": "fd00::1"},
+			map[string]interface{}{"src_ip": "fd00::1"}},
+		{"not an ip", map[string]interface{}{"src_ip": "example.com"},
+			map[string]interface{}{"src_ip": "example.com"}},
+		{"no src_ip", map[string]interface{}{"app": "cowrie"},
+			map[string]interface{}{"app": "cowrie"}},
+	}
+	db := &fakeASNReader{records: map[string]asnRecord{
+		"203.0.113.9": {64500, "Example Net"},
+		"2001:db8::1": {64501, "Example v6"},
+	}}
+	a := &asnEnricher{db: db, cache: make(map[string]asnRecord)}
+	for _, tt := range tests {
+		got, err := a.Process(tt.doc)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	doc := map[string]interface{}{"src_ip": "198.51.100.66"}
+	if _, err := a.Process(doc); err == nil {
+		t.Error("lookup error not returned")
+	}
+	if len(doc) != 1 {
+		t.Errorf("failed lookup changed the document: %v", doc)
+	}
+}
+
+func TestASNEnricherCache(t *testing.T) {
+	db := &fakeASNReader{records: map[string]asnRecord{"203.0.113.9": {64500, "Example Net"}}}
+	a := &asnEnricher{db: db, cache: make(map[string]asnRecord)}
+	for i := 0; i < 3; i++ {
+		a.Process(map[string]interface{}{"src_ip": "203.0.113.9"})
+	}
+	if db.lookups != 1 {
+		t.Errorf("%d lookups for one address, want 1", db.lookups)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// backfill re-runs the document enrichment over the existing documents in
+// index matching query, a query string query or "" for all of them, and
+// writes them back in place under the same _id. Documents are read and
+// written batch documents at a time. Failed writes go through the usual
+// retries and dead letter file.
+func backfill(client *elastic.Client, index, query string, batch int) error {
+	var q elastic.Query = elastic.NewMatchAllQuery()
+	if query != "" {
+		q = elastic.NewQueryStringQuery(query)
+	}
+
+	ctx := context.Background()
+	scroll := client.Scroll(index).Query(q).Size(batch)
+	defer scroll.Clear(ctx)
+
+	total, skipped := 0, 0
+	for {
+		res, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var reqs []elastic.BulkableRequest
+		for _, hit := range res.Hits.Hits {
+			var doc map[string]interface{}
+			var p Payload
+			if err := unmarshalDoc(hit.Source, &doc); err != nil || p.reload(doc) != nil {
+				skipped++
+				continue
+			}
+			doc = enrichDoc(doc, &p, currentSettings())
+
+			req := newBulkIndexRequest().Index(hit.Index).Id(hit.Id).Doc(doc)
+			if hit.Routing != "" {
+				req = req.Routing(hit.Routing)
+			}
+			reqs = append(reqs, req)
+		}
+		if len(reqs) > 0 {
+			flushBulk(client, reqs)
+		}
+		total += len(reqs)
+	}
+
+	fmt.Printf("Backfilled %d documents in %s, skipped %d unreadable\n", total, index, skipped)
+	return nil
+}
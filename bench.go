@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// runBench feeds count synthetic payloads at rate per second (0 for as fast
+// as possible) through processPayloads and reports throughput, flush latency
+// and allocations. With a nil client the batches are only serialized, which
+// measures the enrichment path without ES in the way.
+func runBench(client *elastic.Client, count, rate int) {
+	var mu sync.Mutex
+	var latencies []time.Duration
+	flush := func(reqs []elastic.BulkableRequest) {
+		start := time.Now()
+		if client != nil {
+			flushBulk(client, reqs)
+		} else {
+			serializeBulk(reqs)
+		}
+		mu.Lock()
+		latencies = append(latencies, time.Since(start))
+		mu.Unlock()
+	}
+
+	payloads := benchPayloads(1000)
+	messages := make(chan message, BulkSize)
+	done := make(chan struct{})
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	go func() {
+		processPayloads(messages, flush)
+		close(done)
+	}()
+	for i := 0; i < count; i++ {
+		if rate > 0 {
+			// Pace against the schedule rather than sleeping a fixed
+			// interval, which would drift at high rates.
+			if d := time.Until(start.Add(time.Duration(i) * time.Second / time.Duration(rate))); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		mes := message{Channel: channels[0]}
+		mes.Payload = payloads[i%len(payloads)]
+		messages <- mes
+	}
+	close(messages)
+	<-done
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	fmt.Printf("docs:        %d in %s\n", count, elapsed.Round(time.Millisecond))
+	fmt.Printf("throughput:  %.0f docs/s\n", float64(count)/elapsed.Seconds())
+	fmt.Printf("flushes:     %d, p50 %s, p99 %s\n", len(latencies),
+		percentile(latencies, 50), percentile(latencies, 99))
+	if count > 0 {
+		fmt.Printf("allocations: %d allocs/doc, %d bytes/doc, %d GCs\n",
+			(after.Mallocs-before.Mallocs)/uint64(count),
+			(after.TotalAlloc-before.TotalAlloc)/uint64(count),
+			after.NumGC-before.NumGC)
+	}
+}
+
+// serializeBulk renders reqs the way the bulk API would send them, standing
+// in for a flush in a dry run.
+func serializeBulk(reqs []elastic.BulkableRequest) {
+	for _, req := range reqs {
+		if _, err := req.Source(); err != nil {
+			log.Printf("Serializing bulk request: %v\n", err)
+		}
+	}
+}
+
+// percentile returns the p-th percentile of ds, sorting it in place.
+func percentile(ds []time.Duration, p int) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[(len(ds)-1)*p/100].Round(time.Microsecond)
+}
+
+// benchPayloads generates n synthetic hpfeeds payloads spread over the known
+// apps, with the fields the enrichment path works on.
+func benchPayloads(n int) [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	ip := func() string {
+		return fmt.Sprintf("%d.%d.%d.%d", rng.Intn(223)+1, rng.Intn(256), rng.Intn(256), rng.Intn(254)+1)
+	}
+
+	payloads := make([][]byte, n)
+	for i := range payloads {
+		doc := map[string]interface{}{
+			"app":            Apps[rng.Intn(len(Apps))],
+			"src_ip":         ip(),
+			"dest_ip":        ip(),
+			"src_port":       rng.Intn(65535) + 1,
+			"dest_port":      []int{22, 23, 80, 443, 445, 3389}[rng.Intn(6)],
+			"src_latitude":   rng.Float64()*180 - 90,
+			"src_longitude":  rng.Float64()*360 - 180,
+			"dest_latitude":  rng.Float64()*180 - 90,
+			"dest_longitude": rng.Float64()*360 - 180,
+			"protocol":       "tcp",
+			"timestamp":      time.Now().UTC().Format(time.RFC3339),
+			"signature":      fmt.Sprintf("Connection to honeypot %d", rng.Intn(100)),
+		}
+		payloads[i], _ = json.Marshal(doc)
+	}
+	return payloads
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// breaker is a circuit breaker around ES writes. After threshold consecutive
+// failed bulk requests it opens and refuses writes for cooldown, giving a
+// struggling cluster room to recover. Once the cooldown passes it half-opens
+// and lets the next request through as a probe: success closes it again,
+// failure reopens it for another cooldown.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     string
+	openedAt  time.Time
+}
+
+// newBreaker returns a closed breaker. A threshold of 0 or less disables it.
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow reports whether a write may be attempted now.
+func (b *breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	return true
+}
+
+// Success records a write that reached ES.
+func (b *breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed)
+	}
+}
+
+// Failure records a write that didn't reach ES.
+func (b *breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 {
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		if b.state != BreakerOpen {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// Probe checks on ES with ping once the cooldown has passed, rather than
+// waiting for the next flush to risk a batch of documents as the probe.
+// Success closes the breaker and failure restarts the cooldown.
+func (b *breaker) Probe(ping func() error) {
+	b.mu.Lock()
+	if b.state != BreakerOpen || time.Since(b.openedAt) < b.cooldown {
+		b.mu.Unlock()
+		return
+	}
+	b.setState(BreakerHalfOpen)
+	b.mu.Unlock()
+
+	if err := ping(); err != nil {
+		log.Printf("Circuit breaker probe failed: %v\n", err)
+		b.Failure()
+		return
+	}
+	b.Success()
+}
+
+// State returns the current state name.
+func (b *breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *breaker) setState(state string) {
+	log.Printf("Circuit breaker %s -> %s\n", b.state, state)
+	b.state = state
+}
+
+// probeBreaker probes ES every cooldown while the breaker is open, so writes
+// resume as soon as the cluster is back even if no flush comes along. It
+// returns on shutdown.
+func probeBreaker(b *breaker, client *elastic.Client) {
+	for !sleepOrShutdown(b.cooldown) {
+		b.Probe(func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), b.cooldown)
+			defer cancel()
+			res, err := client.ClusterHealth().Do(ctx)
+			if err != nil {
+				return err
+			}
+			if res.Status == "red" {
+				return fmt.Errorf("cluster health is red")
+			}
+			return nil
+		})
+	}
+}
+
+// retryBudget limits bulk retries to a fraction of the bulk requests sent,
+// so a cluster that is rejecting everything as overloaded isn't hit with
+// several times the load in retries. Every request sent earns ratio tokens
+// and every retry spends one. It starts with, and holds at most,
+// RetryBudgetReserve tokens, enough to ride out a short blip.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+// RetryBudgetReserve is the most retries a retryBudget saves up.
+const RetryBudgetReserve = 10
+
+// newRetryBudget returns a full budget. A ratio of 0 or less disables it.
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, tokens: RetryBudgetReserve}
+}
+
+// Sent records a bulk request sent for the first time.
+func (r *retryBudget) Sent() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens += r.ratio
+	if r.tokens > RetryBudgetReserve {
+		r.tokens = RetryBudgetReserve
+	}
+}
+
+// Retry reports whether a retry may be sent, spending from the budget if
+// so.
+func (r *retryBudget) Retry() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ratio <= 0 {
+		return true
+	}
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// failedRequest is a bulk request ES rejected, with the reason why and the
+// ES error type, if any.
+type failedRequest struct {
+	req     elastic.BulkableRequest
+	reason  string
+	errType string
+}
+
+// newBulkIndexRequest returns a bulk index request for the _doc type, or
+// without a type at all with -no-type.
+func newBulkIndexRequest() *elastic.BulkIndexRequest {
+	req := elastic.NewBulkIndexRequest()
+	if !noType {
+		req = req.Type("_doc")
+	}
+	return req
+}
+
+// idRequest sets the _id of req from doc's -id-field, and then its version
+// with -version-field. Documents without the field, or with a value that
+// isn't a scalar, are left to the automatic _id ES assigns. A null or empty
+// field counts as missing.
+func idRequest(req *elastic.BulkIndexRequest, doc map[string]interface{}, app string) *elastic.BulkIndexRequest {
+	v, ok := lookupField(doc, idField)
+	if !ok || v == nil || v == "" {
+		return req
+	}
+	id, ok := scalarString(v)
+	if !ok {
+		parseLog.Printf("Ignoring non-scalar %s in %s document, using an automatic _id\n", idField, app)
+		return req
+	}
+	req = req.Id(id)
+	if versionField != "" {
+		req = versionRequest(req, doc, app)
+	}
+	return req
+}
+
+// versionRequest sets the external version of req from doc's -version-field,
+// so ES only applies it over an older version of the document. Documents
+// without a usable version are indexed unversioned.
+func versionRequest(req *elastic.BulkIndexRequest, doc map[string]interface{}, app string) *elastic.BulkIndexRequest {
+	v, ok := lookupField(doc, versionField)
+	if !ok {
+		return req
+	}
+	version, ok := versionNumber(v)
+	if !ok {
+		parseLog.Printf("Ignoring invalid %s %v in %s document, indexing it unversioned\n", versionField, v, app)
+		return req
+	}
+	return req.Version(version).VersionType("external")
+}
+
+// flushBulk sends reqs to ES as a single bulk request. Items that fail for
+// transient reasons, such as a server side timeout or a full write queue,
+// are sent again up to -bulk-retries times with exponential backoff, as long
+// as the retry budget allows. Items that can't be delivered go to the dead
+// letter file. While the circuit breaker is open nothing is sent and the
+// whole batch is dead lettered. A single summary line is logged per flush.
+//
+// With -conflict-fallback, documents rejected because they don't fit the
+// mapping are indexed into a schemaless fallback index instead of the dead
+// letter file.
+func flushBulk(client *elastic.Client, reqs []elastic.BulkableRequest) {
+	total := len(reqs)
+	size := client.Bulk().Add(reqs...).EstimatedSizeInBytes()
+	start := time.Now()
+	failed := 0
+	var salvaged []elastic.BulkableRequest // Mapping conflicts for fallback indexes.
+
+	backoff := time.Second
+	attempt := 0
+	for ; ; attempt++ {
+		if !esBreaker.Allow() {
+			log.Printf("Circuit breaker open, dead lettering %d of %d records\n", len(reqs), total)
+			deadLetterAll(reqs, "circuit breaker open")
+			failed += len(reqs)
+			break
+		}
+
+		if attempt == 0 {
+			bulkRetryBudget.Sent()
+		}
+		retry, rejected, err := sendBulk(client, reqs)
+		switch {
+		case err != nil:
+			bulkLog.Printf("Bulk request failed: %v\n", err)
+			esBreaker.Failure()
+			flushFailed(err.Error())
+			retry = reqs
+		case len(retry) == len(reqs):
+			// Every item was turned away as overloaded, which is as good
+			// as a failed request for the breaker.
+			esBreaker.Failure()
+		default:
+			esBreaker.Success()
+			flushSucceeded()
+		}
+		if len(rejected) > 0 {
+			flushFailed(rejected[0].reason)
+		}
+		for _, f := range rejected {
+			if conflictFallback && mappingConflict(f.errType) {
+				if req, ok := fallbackRequest(client, f); ok {
+					salvaged = append(salvaged, req)
+					continue
+				}
+			}
+			deadLetter(f.req, f.reason)
+			failed++
+		}
+
+		if len(retry) == 0 {
+			break
+		}
+		if attempt >= bulkRetries {
+			log.Printf("Giving up on %d of %d records after %d retries\n", len(retry), total, attempt)
+			retriesExhausted.Add(int64(len(retry)))
+			deadLetterAll(retry, "retries exhausted")
+			failed += len(retry)
+			break
+		}
+
+		if !bulkRetryBudget.Retry() {
+			log.Printf("Retry budget exhausted, dead lettering %d of %d records\n", len(retry), total)
+			retryBudgetExhausted.Add(int64(len(retry)))
+			deadLetterAll(retry, "retry budget exhausted")
+			failed += len(retry)
+			break
+		}
+
+		bulkRetriesTotal.Add(int64(len(retry)))
+		time.Sleep(backoff)
+		backoff *= 2
+		reqs = retry
+	}
+
+	log.Printf("Flushed batch: docs=%d failed=%d fallback=%d bytes=%d retries=%d duration=%s\n",
+		total, failed, len(salvaged), size, attempt, time.Since(start).Round(time.Millisecond))
+
+	// Fallback documents can't conflict again, and are never salvaged a
+	// second time, so this recurses at most once.
+	if len(salvaged) > 0 {
+		conflictFallbacks.Add(int64(len(salvaged)))
+		flushBulk(client, salvaged)
+	}
+}
+
+// inflightSlots limits how many bulk requests are sent at once when
+// -max-inflight is set; nil means no limit. inflight counts the requests
+// being sent.
+var (
+	inflightSlots chan struct{}
+	inflight      int64
+)
+
+// acquireInflight waits for a free -max-inflight slot, so a flusher blocks
+// instead of piling onto a busy cluster.
+func acquireInflight() {
+	if inflightSlots != nil {
+		inflightSlots <- struct{}{}
+	}
+	atomic.AddInt64(&inflight, 1)
+}
+
+func releaseInflight() {
+	atomic.AddInt64(&inflight, -1)
+	if inflightSlots != nil {
+		<-inflightSlots
+	}
+}
+
+// sendBulk performs one bulk request and returns the requests whose items
+// failed in a way worth retrying, along with the requests that were rejected
+// permanently, so only those are sent again and items ES already accepted
+// aren't duplicated. Response items are in the same order as the requests,
+// which is how they are matched back up; a response that doesn't have one
+// item per request can't be matched, and is returned as an error so the
+// whole batch is retried rather than some of it silently lost.
+func sendBulk(client *elastic.Client, reqs []elastic.BulkableRequest) ([]elastic.BulkableRequest, []failedRequest, error) {
+	bulkRequest := client.Bulk().Add(reqs...)
+	if bulkTimeout > 0 {
+		bulkRequest = bulkRequest.Timeout(bulkTimeout.String())
+	}
+
+	acquireInflight()
+	res, err := bulkRequest.Do(context.Background())
+	releaseInflight()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !res.Errors {
+		return nil, nil, nil
+	}
+
+	if len(res.Items) != len(reqs) {
+		return nil, nil, fmt.Errorf("bulk response has %d items for %d requests", len(res.Items), len(reqs))
+	}
+
+	var retry []elastic.BulkableRequest
+	var rejected []failedRequest
+	for i, items := range res.Items {
+		for _, item := range items {
+			switch {
+			case item.Status >= 200 && item.Status <= 299:
+			case bulkAction == "create" && item.Status == http.StatusConflict:
+				duplicateDocs.Add(1)
+			case versionField != "" && versionConflict(item):
+				staleSkipped.Add(1)
+			case retryableItem(item):
+				retry = append(retry, reqs[i])
+			default:
+				f := failedRequest{req: reqs[i], reason: itemError(item)}
+				if item.Error != nil {
+					f.errType = item.Error.Type
+				}
+				rejected = append(rejected, f)
+			}
+		}
+	}
+
+	if len(rejected) > 0 {
+		bulkLog.Printf("Bulk items rejected, first: %s\n", rejected[0].reason)
+	}
+	return retry, rejected, nil
+}
+
+// retryableItem reports whether a failed bulk item may succeed if sent
+// again: server side timeouts and rejections due to load.
+func retryableItem(item *elastic.BulkResponseItem) bool {
+	switch item.Status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return item.Error != nil && strings.Contains(item.Error.Type, "timeout")
+}
+
+// versionConflict reports whether item was turned away because ES already
+// has the same or a newer version of the document, which with
+// -version-field means the document was stale rather than failed.
+func versionConflict(item *elastic.BulkResponseItem) bool {
+	return item.Status == http.StatusConflict && item.Error != nil &&
+		item.Error.Type == "version_conflict_engine_exception"
+}
+
+// itemError describes why a bulk item failed.
+func itemError(item *elastic.BulkResponseItem) string {
+	if item.Error == nil {
+		return fmt.Sprintf("status %d", item.Status)
+	}
+	return fmt.Sprintf("status %d: %s: %s", item.Status, item.Error.Type, item.Error.Reason)
+}
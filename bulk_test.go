@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// fakeES returns a client for a fake ES served by h.
+func fakeES(t *testing.T, h http.HandlerFunc) *elastic.Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	client, err := elastic.NewClient(elastic.SetURL(srv.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bulkLog == nil {
+		bulkLog = newSampledLogger(0, 0, 0)
+	}
+	return client
+}
+
+// fakeBulkClient returns a client for a fake ES that answers every bulk
+// request with response.
+func fakeBulkClient(t *testing.T, response string) *elastic.Client {
+	t.Helper()
+	return fakeES(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	})
+}
+
+// testRequests returns n bulk index requests with ids 0 to n-1.
+func testRequests(n int) []elastic.BulkableRequest {
+	var reqs []elastic.BulkableRequest
+	for i := 0; i < n; i++ {
+		reqs = append(reqs, newBulkIndexRequest().Index("test").Id(strconv.Itoa(i)).Doc(map[string]int{"n": i}))
+	}
+	return reqs
+}
+
+func TestSendBulkCorrelatesItems(t *testing.T) {
+	client := fakeBulkClient(t, `{"took": 1, "errors": true, "items": [
+		{"index": {"_index": "test", "_id": "0", "status": 201}},
+		{"index": {"_index": "test", "_id": "1", "status": 429, "error": {"type": "es_rejected_execution_exception", "reason": "queue full"}}},
+		{"index": {"_index": "test", "_id": "2", "status": 400, "error": {"type": "mapper_parsing_exception", "reason": "failed to parse field [port]"}}},
+		{"index": {"_index": "test", "_id": "3", "status": 200}},
+		{"index": {"_index": "test", "_id": "4", "status": 500, "error": {"type": "process_cluster_event_timeout_exception", "reason": "timed out"}}}
+	]}`)
+	reqs := testRequests(5)
+
+	retry, rejected, err := sendBulk(client, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retry) != 2 || retry[0] != reqs[1] || retry[1] != reqs[4] {
+		t.Errorf("retry = %v, want requests 1 and 4", retry)
+	}
+	if len(rejected) != 1 || rejected[0].req != reqs[2] {
+		t.Fatalf("rejected = %v, want request 2", rejected)
+	}
+	if rejected[0].errType != "mapper_parsing_exception" {
+		t.Errorf("rejected error type = %q", rejected[0].errType)
+	}
+}
+
+func TestSendBulkItemCountMismatch(t *testing.T) {
+	client := fakeBulkClient(t, `{"took": 1, "errors": true, "items": [
+		{"index": {"_index": "test", "_id": "0", "status": 429}}
+	]}`)
+	retry, rejected, err := sendBulk(client, testRequests(2))
+	if err == nil {
+		t.Errorf("mismatched response accepted, retry = %v, rejected = %v", retry, rejected)
+	}
+}
+
+// versioningES is a fake ES bulk endpoint that applies external versions
+// like ES does: a document is only replaced by a higher version.
+func versioningES(t *testing.T, versions map[string]int64) *elastic.Client {
+	t.Helper()
+	return fakeES(t, func(w http.ResponseWriter, r *http.Request) {
+		dec := json.NewDecoder(r.Body)
+		var items []map[string]interface{}
+		conflicts := false
+		for {
+			var action map[string]struct {
+				ID      string `json:"_id"`
+				Version int64  `json:"version"`
+			}
+			if err := dec.Decode(&action); err != nil {
+				break
+			}
+			var doc json.RawMessage
+			dec.Decode(&doc)
+			a := action["index"]
+			status := http.StatusCreated
+			item := map[string]interface{}{"_index": "test", "_id": a.ID}
+			if v, ok := versions[a.ID]; ok && a.Version <= v {
+				status = http.StatusConflict
+				item["error"] = map[string]string{"type": "version_conflict_engine_exception"}
+				conflicts = true
+			} else {
+				versions[a.ID] = a.Version
+			}
+			item["status"] = status
+			items = append(items, map[string]interface{}{"index": item})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": conflicts, "items": items})
+	})
+}
+
+func TestSendBulkOutOfOrderVersions(t *testing.T) {
+	defer func(old string) { versionField = old }(versionField)
+	versionField = "seq"
+	if parseLog == nil {
+		parseLog = newSampledLogger(0, 0, 0)
+	}
+	versions := make(map[string]int64)
+	client := versioningES(t, versions)
+
+	// The same event arrives as seq 2, 1 (a late replay), 3 and 3 again.
+	tests := []struct {
+		seq   interface{}
+		stale bool
+	}{
+		{json.Number("2"), false},
+		{json.Number("1"), true},
+		{"3", false},
+		{float64(3), true},
+	}
+	for _, tt := range tests {
+		doc := map[string]interface{}{"seq": tt.seq}
+		req := versionRequest(newBulkIndexRequest().Index("test").Id("event").Doc(doc), doc, "cowrie")
+
+		before := staleSkipped.Value()
+		retry, rejected, err := sendBulk(client, []elastic.BulkableRequest{req})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(retry) != 0 || len(rejected) != 0 {
+			t.Errorf("seq %v: retry = %v, rejected = %v, want neither", tt.seq, retry, rejected)
+		}
+		if stale := staleSkipped.Value() > before; stale != tt.stale {
+			t.Errorf("seq %v: counted as stale = %v, want %v", tt.seq, stale, tt.stale)
+		}
+	}
+	if versions["event"] != 3 {
+		t.Errorf("stored version = %d, want 3", versions["event"])
+	}
+}
+
+func TestNewBulkIndexRequestType(t *testing.T) {
+	tests := []struct {
+		noType bool
+		want   string
+	}{
+		{false, `{"index":{"_index":"test","_id":"1","_type":"_doc"}}`},
+		{true, `{"index":{"_index":"test","_id":"1"}}`},
+	}
+	defer func(old bool) { noType = old }(noType)
+	for _, tt := range tests {
+		noType = tt.noType
+		lines, err := newBulkIndexRequest().Index("test").Id("1").Doc(map[string]int{"n": 1}).Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines[0] != tt.want {
+			t.Errorf("-no-type=%v: action line %s, want %s", tt.noType, lines[0], tt.want)
+		}
+	}
+}
+
+func TestIDRequestFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string // The action line.
+	}{
+		{"string", `{"event_id": "abc"}`, `{"index":{"_index":"test","_id":"abc"}}`},
+		{"number", `{"event_id": 12345678901234567}`, `{"index":{"_index":"test","_id":"12345678901234567"}}`},
+		{"nested", `{"event": {"id": "n1"}}`, `{"index":{"_index":"test"}}`},
+		{"missing", `{"src_ip": "192.0.2.1"}`, `{"index":{"_index":"test"}}`},
+		{"null", `{"event_id": null}`, `{"index":{"_index":"test"}}`},
+		{"empty", `{"event_id": ""}`, `{"index":{"_index":"test"}}`},
+		{"object", `{"event_id": {"a": 1}}`, `{"index":{"_index":"test"}}`},
+		{"array", `{"event_id": [1, 2]}`, `{"index":{"_index":"test"}}`},
+	}
+	defer func(oldID string, oldType bool) { idField, noType = oldID, oldType }(idField, noType)
+	idField, noType = "event_id", true
+	if parseLog == nil {
+		parseLog = newSampledLogger(0, 0, 0)
+	}
+	out := captureLog(t)
+	for _, tt := range tests {
+		var doc map[string]interface{}
+		if err := unmarshalDoc([]byte(tt.doc), &doc); err != nil {
+			t.Fatal(err)
+		}
+		lines, err := idRequest(newBulkIndexRequest().Index("test").Doc(doc), doc, "cowrie").Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines[0] != tt.want {
+			t.Errorf("%s: action line %s, want %s", tt.name, lines[0], tt.want)
+		}
+	}
+	// Only the object and the array are worth a warning; null and empty
+	// are as good as missing.
+	if n := strings.Count(out.String(), "Ignoring non-scalar"); n != 2 {
+		t.Errorf("%d non-scalar warnings, want 2:\n%s", n, out)
+	}
+
+	// Enrichment sets fields of Go integer types, like src_asn.
+	for _, v := range []interface{}{uint(64500), uint32(64500), int64(64500)} {
+		doc := map[string]interface{}{"event_id": v}
+		lines, err := idRequest(newBulkIndexRequest().Index("test").Doc(doc), doc, "cowrie").Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `{"index":{"_index":"test","_id":"64500"}}`; lines[0] != want {
+			t.Errorf("%T id: action line %s, want %s", v, lines[0], want)
+		}
+	}
+
+	// Dotted names reach into nested objects.
+	idField = "event.id"
+	doc := map[string]interface{}{"event": map[string]interface{}{"id": "n1"}}
+	lines, err := idRequest(newBulkIndexRequest().Index("test").Doc(doc), doc, "cowrie").Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"index":{"_index":"test","_id":"n1"}}`; lines[0] != want {
+		t.Errorf("nested id: action line %s, want %s", lines[0], want)
+	}
+}
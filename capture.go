@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// captureEntry is one hpfeeds message in a -record capture file, which
+// holds one JSON object per line. The payload is kept byte for byte, so
+// unparseable messages replay exactly as they arrived.
+type captureEntry struct {
+	Time    time.Time `json:"time"`
+	Broker  string    `json:"broker,omitempty"`
+	Channel string    `json:"channel"`
+	Ident   string    `json:"ident,omitempty"`
+	Payload []byte    `json:"payload"`
+}
+
+// captureCodec returns the codec for a capture file: -compression if set,
+// otherwise zstd for names ending in .zst or .zstd and none for the rest.
+func captureCodec(path string) string {
+	if compression != "" {
+		return compression
+	}
+	if strings.HasSuffix(path, ".zst") || strings.HasSuffix(path, ".zstd") {
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// recorder appends every received message to a capture file. It is only
+// used from processPayloads.
+type recorder struct {
+	f   *os.File
+	zw  io.WriteCloser
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+// newRecorder opens path for appending captured messages, compressed as
+// captureCodec says. Appending to an existing compressed capture adds a new
+// gzip member or zstd frame, which readers handle transparently.
+func newRecorder(path string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := &recorder{f: f}
+	if r.zw, err = newCompressWriter(f, captureCodec(path)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.buf = bufio.NewWriter(r.zw)
+	r.enc = json.NewEncoder(r.buf)
+	return r, nil
+}
+
+// Record appends mes to the capture.
+func (r *recorder) Record(mes message) {
+	t := mes.Received
+	if t.IsZero() {
+		t = time.Now()
+	}
+	err := r.enc.Encode(captureEntry{
+		Time:    t.UTC(),
+		Broker:  mes.Broker,
+		Channel: mes.Channel,
+		Ident:   mes.Name,
+		Payload: mes.Payload,
+	})
+	if err != nil {
+		log.Printf("Error recording message: %v\n", err)
+	}
+}
+
+// Close flushes the buffer and ends the gzip member or zstd frame before
+// closing the file. Skipping this truncates a compressed capture.
+func (r *recorder) Close() error {
+	if err := r.buf.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	if err := r.zw.Close(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+// replayCapture feeds the messages of a capture file into out, then starts
+// a shutdown so the pipeline drains and the process exits.
+func replayCapture(path string, out chan message) error {
+	defer startShutdown()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Whatever the codec was when recording, it's recognized from the
+	// file itself.
+	r, err := newDecompressReader(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	n := 0
+	dec := json.NewDecoder(r)
+	for {
+		var e captureEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		mes := message{Channel: e.Channel, Broker: e.Broker, Received: e.Time}
+		mes.Name = e.Ident
+		mes.Payload = e.Payload
+		select {
+		case out <- mes:
+			n++
+		case <-shuttingDown:
+			log.Printf("Replay interrupted after %d messages\n", n)
+			return nil
+		}
+	}
+	log.Printf("Replayed %d messages from %s\n", n, path)
+	return nil
+}
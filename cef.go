@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Payload formats for -payload-format.
+const (
+	FormatJSON = "json"
+	FormatCEF  = "cef"
+	FormatLEEF = "leef"
+)
+
+// cefFields and leefFields map the CEF and LEEF keys the enrichment relies
+// on to the names JSON payloads use. Other keys are kept as they are.
+var (
+	cefFields = map[string]string{
+		"src":   "src_ip",
+		"dst":   "dest_ip",
+		"spt":   "src_port",
+		"dpt":   "dest_port",
+		"proto": "transport",
+		"slat":  "src_latitude",
+		"slong": "src_longitude",
+		"dlat":  "dest_latitude",
+		"dlong": "dest_longitude",
+	}
+	leefFields = map[string]string{
+		"src":     "src_ip",
+		"dst":     "dest_ip",
+		"srcPort": "src_port",
+		"dstPort": "dest_port",
+		"proto":   "transport",
+	}
+)
+
+// coordFields hold coordinates, which are parsed as numbers since the
+// enrichment reads them as such.
+var coordFields = []string{"src_latitude", "src_longitude", "dest_latitude", "dest_longitude"}
+
+// parseCEF parses a CEF event, optionally preceded by a syslog header:
+//
+//	CEF:0|Vendor|Product|Version|Signature ID|Name|Severity|key=value ...
+//
+// The header becomes the device_vendor, device_product, etc. fields, with
+// the device product as the app, and the extension's keys become fields.
+func parseCEF(line string) (map[string]interface{}, error) {
+	i := strings.Index(line, "CEF:")
+	if i < 0 {
+		return nil, errors.New("no CEF header")
+	}
+	parts := splitHeader(strings.TrimRight(line[i+len("CEF:"):], "\r\n"), 8)
+	if len(parts) < 8 {
+		return nil, fmt.Errorf("CEF header has %d of 7 fields", len(parts)-1)
+	}
+
+	doc := map[string]interface{}{
+		"cef_version":    parts[0],
+		"device_vendor":  parts[1],
+		"device_product": parts[2],
+		"device_version": parts[3],
+		"signature_id":   parts[4],
+		"name":           parts[5],
+		"severity":       parts[6],
+	}
+	for k, v := range parseCEFExtension(parts[7]) {
+		addExtensionField(doc, cefFields, k, v)
+	}
+	return finishEvent(doc, parts[2])
+}
+
+// parseLEEF parses a LEEF 1.0 or 2.0 event, optionally preceded by a syslog
+// header:
+//
+//	LEEF:1.0|Vendor|Product|Version|EventID|key=value<tab>...
+//	LEEF:2.0|Vendor|Product|Version|EventID|^|key=value^...
+//
+// LEEF 2.0 names the attribute delimiter, as a character or in hex such as
+// x09; LEEF 1.0 always uses tabs.
+func parseLEEF(line string) (map[string]interface{}, error) {
+	i := strings.Index(line, "LEEF:")
+	if i < 0 {
+		return nil, errors.New("no LEEF header")
+	}
+	line = strings.TrimRight(line[i+len("LEEF:"):], "\r\n")
+
+	n := 6
+	if strings.HasPrefix(line, "2.") {
+		n = 7
+	}
+	parts := splitHeader(line, n)
+	if len(parts) < n {
+		return nil, fmt.Errorf("LEEF header has %d of %d fields", len(parts)-1, n-1)
+	}
+
+	delim := "\t"
+	if n == 7 {
+		d, err := leefDelimiter(parts[5])
+		if err != nil {
+			return nil, err
+		}
+		delim = d
+	}
+
+	doc := map[string]interface{}{
+		"leef_version":   parts[0],
+		"device_vendor":  parts[1],
+		"device_product": parts[2],
+		"device_version": parts[3],
+		"event_id":       parts[4],
+	}
+	for _, attr := range strings.Split(parts[n-1], delim) {
+		k, v, ok := splitKeyValue(attr)
+		if !ok {
+			continue
+		}
+		addExtensionField(doc, leefFields, k, v)
+	}
+	return finishEvent(doc, parts[2])
+}
+
+// leefDelimiter decodes a LEEF 2.0 delimiter: a single character, or its
+// code in hex as x09 or 0x09. Empty means the default tab.
+func leefDelimiter(s string) (string, error) {
+	switch {
+	case s == "":
+		return "\t", nil
+	case utf8.RuneCountInString(s) == 1:
+		return s, nil
+	}
+	lower := strings.ToLower(s)
+	hex := strings.TrimPrefix(strings.TrimPrefix(lower, "0x"), "x")
+	code, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || hex == lower {
+		return "", fmt.Errorf("invalid LEEF delimiter %q", s)
+	}
+	return string(rune(code)), nil
+}
+
+// splitHeader splits the pipe separated header of a CEF or LEEF event into
+// at most n parts, the last being the rest of the line. Escaped pipes and
+// backslashes in the header are unescaped.
+func splitHeader(s string, n int) []string {
+	var parts []string
+	var cur strings.Builder
+	for i := 0; i < len(s); i++ {
+		if len(parts) == n-1 {
+			return append(parts, s[i:])
+		}
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '|' || s[i+1] == '\\'):
+			cur.WriteByte(s[i+1])
+			i++
+		case c == '|':
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if len(parts) == n-1 {
+		// Nothing after the last pipe.
+		return append(parts, "")
+	}
+	return append(parts, cur.String())
+}
+
+// parseCEFExtension parses the space separated key=value pairs of a CEF
+// extension. Values may contain spaces, so each runs up to the space before
+// the next key. Escaped equals signs, backslashes and newlines in values are
+// unescaped, and an unescaped equals sign inside a value is taken as is.
+func parseCEFExtension(s string) map[string]string {
+	fields := make(map[string]string)
+	key := ""
+	var val []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch c = s[i]; c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			}
+			val = append(val, c)
+		case c == '=':
+			// The word before an equals sign is the next key, and ends
+			// the previous value.
+			j := bytes.LastIndexByte(val, ' ')
+			if key != "" && j < 0 {
+				val = append(val, c)
+				continue
+			}
+			if key != "" {
+				fields[key] = strings.TrimSpace(string(val[:j]))
+			}
+			key = strings.TrimSpace(string(val[j+1:]))
+			val = val[:0]
+		default:
+			val = append(val, c)
+		}
+	}
+	if key != "" {
+		fields[key] = strings.TrimSpace(string(val))
+	}
+	return fields
+}
+
+// addExtensionField adds the key=value pair to doc, under the JSON name
+// from names if it has one.
+func addExtensionField(doc map[string]interface{}, names map[string]string, k, v string) {
+	if name, ok := names[k]; ok {
+		k = name
+	}
+	if k == "" || v == "" {
+		return
+	}
+	doc[k] = v
+}
+
+// finishEvent sets the app of a parsed event to its device product, and
+// turns coordinates into numbers, dropping those that aren't.
+func finishEvent(doc map[string]interface{}, product string) (map[string]interface{}, error) {
+	if product == "" {
+		return nil, errors.New("no device product to use as the app")
+	}
+	doc["app"] = product
+	for _, f := range coordFields {
+		s, ok := doc[f].(string)
+		if !ok {
+			continue
+		}
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			doc[f] = v
+		} else {
+			delete(doc, f)
+			invalidTypedFields.Add(f, 1)
+		}
+	}
+	return doc, nil
+}
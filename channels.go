@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/d1str0/hpfeeds-elastic/internal/hpfeeds"
+)
+
+// message is an hpfeeds message along with the channel and broker it arrived
+// from and when. Channel is the hpfeeds channel for messages from a broker,
+// and what the listener or replay file says for the rest.
+type message struct {
+	hpfeeds.Message
+	Channel  string
+	Broker   string
+	Received time.Time
+}
+
+// subscription is the Go channel the hpfeeds client delivers one hpfeeds
+// channel's messages on, or, with an empty name, those on channels the
+// broker wasn't asked for. Subscriptions are created once and handed to the
+// client, which keeps them across reconnects, so the forwarding goroutines
+// live until shutdown.
+type subscription struct {
+	name string
+	ch   chan hpfeeds.Message
+}
+
+// parseChannels splits a comma separated channel list.
+func parseChannels(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// newSubscriptions creates a subscription per channel of b, each forwarding
+// its messages into out tagged with the channel and broker name. The last
+// one is for messages on channels b didn't subscribe to.
+func newSubscriptions(b *broker, out chan message) []subscription {
+	var subs []subscription
+	names := append(append([]string(nil), b.channels...), "")
+	for _, name := range names {
+		sub := subscription{name, make(chan hpfeeds.Message)}
+		inputs.Add(1)
+		go func(sub subscription) {
+			defer inputs.Done()
+			for {
+				select {
+				case m := <-sub.ch:
+					b.touch()
+					if sub.name != "" {
+						b.state.Delivered(sub.name)
+					}
+					messagesReceived.Add(1)
+					select {
+					case out <- message{m, m.Channel, b.name, time.Now()}:
+					case <-shuttingDown:
+						return
+					}
+				case <-shuttingDown:
+					return
+				}
+			}
+		}(sub)
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// expectedChannel reports whether messages from channel should be indexed.
+// Brokers only send what we subscribed to unless they're misconfigured,
+// which is worth knowing about.
+func expectedChannel(channel string) bool {
+	if acceptAnyChannel || replayFile != "" {
+		return true
+	}
+	for _, name := range channels {
+		if name == channel {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+func TestUnexpectedChannelDropped(t *testing.T) {
+	withShutdown(t)
+	oldChannels, oldLog := channels, channelLog
+	defer func() { channels, channelLog = oldChannels, oldLog }()
+	channels = []string{"cowrie.sessions"}
+	channelLog = newSampledLogger(0, 0, 0)
+
+	// The broker pushes a channel the client never subscribed to.
+	addr, stopBroker := fakeBroker(t,
+		hpfeedsFrame(3, []string{"sensor", "dionaea.connections"}, "{}")) // OpPublish
+	defer stopBroker()
+	b := &broker{
+		name:     "fake",
+		host:     addr.IP.String(),
+		port:     addr.Port,
+		ident:    "ident",
+		auth:     "secret",
+		channels: channels,
+		state:    newConnState("fake", channels),
+	}
+	out := make(chan message)
+	inputs.Add(1)
+	go func() {
+		defer inputs.Done()
+		b.run(out, false)
+	}()
+	defer func() {
+		startShutdown()
+		inputs.Wait()
+	}()
+
+	var mes message
+	select {
+	case mes = <-out:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message on the unsubscribed channel wasn't delivered")
+	}
+	if mes.Channel != "dionaea.connections" || mes.Broker != "fake" {
+		t.Fatalf("got message on %q from %q, want dionaea.connections from fake", mes.Channel, mes.Broker)
+	}
+
+	before := unexpectedChannelMessages.Value()
+	messages := make(chan message, 1)
+	messages <- mes
+	close(messages)
+	processPayloads(messages, func(reqs []elastic.BulkableRequest) {
+		t.Errorf("flushed %d requests for a message on an unexpected channel", len(reqs))
+	})
+	if n := unexpectedChannelMessages.Value() - before; n != 1 {
+		t.Errorf("unexpected_channel_messages_total went up by %d, want 1", n)
+	}
+}
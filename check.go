@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/d1str0/hpfeeds-elastic/internal/hpfeeds"
+	"github.com/olivere/elastic/v7"
+)
+
+// runChecks tries every hpfeeds broker and ES, printing a pass or fail line
+// for each, and reports whether all of them passed. A broker passes once it
+// accepts our credentials and stays connected; a message arriving within
+// timeout is reported but not required, as quiet channels are normal.
+func runChecks(timeout time.Duration) bool {
+	ok := true
+	for _, b := range brokers {
+		detail, err := checkBroker(b, timeout)
+		ok = report("hpfeeds "+b.name, detail, err) && ok
+	}
+	url := elasticURL
+	if cloudID != "" {
+		url, _ = decodeCloudID(cloudID)
+	}
+	detail, err := checkElastic(url, timeout)
+	ok = report("elasticsearch", detail, err) && ok
+	if writeURL != "" {
+		detail, err := checkElastic(writeURL, timeout, elastic.SetURL(writeURL))
+		ok = report("elasticsearch write", detail, err) && ok
+	}
+	return ok
+}
+
+func report(name, detail string, err error) bool {
+	if err != nil {
+		fmt.Printf("FAIL  %s: %v\n", name, err)
+		return false
+	}
+	fmt.Printf("PASS  %s: %s\n", name, detail)
+	return true
+}
+
+// checkBroker connects to b, subscribes to its channels and waits up to
+// timeout for a message. The hpfeeds protocol has no explicit auth reply;
+// brokers drop connections with bad credentials instead, so a disconnect
+// while waiting counts as a failure.
+func checkBroker(b *broker, timeout time.Duration) (string, error) {
+	hp := hpfeeds.NewClient(b.host, b.port, b.ident, b.auth)
+
+	connected := make(chan error, 1)
+	go func() { connected <- hp.Connect() }()
+	select {
+	case err := <-connected:
+		if err != nil {
+			return "", fmt.Errorf("connect: %v", err)
+		}
+	case <-time.After(timeout):
+		return "", fmt.Errorf("connect: timed out after %s", timeout)
+	}
+	defer hp.Close()
+
+	msgs := make(chan hpfeeds.Message, 1)
+	for _, c := range b.channels {
+		hp.Subscribe(c, msgs)
+	}
+
+	select {
+	case m := <-msgs:
+		return fmt.Sprintf("connected, received a %d byte message on %s", len(m.Payload), m.Channel), nil
+	case err := <-hp.Disconnected:
+		return "", fmt.Errorf("disconnected by broker, check ident, secret and channel permissions: %v", err)
+	case <-time.After(timeout):
+		return fmt.Sprintf("connected, no message within %s", timeout), nil
+	}
+}
+
+// checkElastic creates the ES client with opts, which fails unless a node
+// is reachable, and pings the cluster at url for its version.
+func checkElastic(url string, timeout time.Duration, opts ...elastic.ClientOptionFunc) (string, error) {
+	client, err := newElasticClient(opts...)
+	if err != nil {
+		return "", err
+	}
+	defer client.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	info, _, err := client.Ping(url).Do(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ping: %v", err)
+	}
+	return fmt.Sprintf("cluster %q, version %s", info.ClusterName, info.Version.Number), nil
+}
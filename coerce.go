@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldTypes maps dotted field paths to their type in the mapping file.
+type fieldTypes map[string]string
+
+// mappingTypes collects the field types from mapping properties.
+func mappingTypes(props map[string]interface{}, prefix string, types fieldTypes) fieldTypes {
+	if types == nil {
+		types = make(fieldTypes)
+	}
+	for name, v := range props {
+		f, _ := v.(map[string]interface{})
+		if sub, ok := f["properties"].(map[string]interface{}); ok {
+			mappingTypes(sub, prefix+name+".", types)
+			continue
+		}
+		if t, ok := f["type"].(string); ok {
+			types[prefix+name] = t
+		}
+	}
+	return types
+}
+
+// coerceDoc converts the values of mapped fields in doc to their mapped
+// type, so ES doesn't reject the document with a mapper_parsing_exception,
+// and reports whether doc had any such field. Values that can't be
+// converted are dropped and counted in invalid_typed_fields_total. Flattened
+// dotted keys are matched against the same paths as nested objects.
+func coerceDoc(doc map[string]interface{}, types fieldTypes, prefix string) bool {
+	changed := false
+	for k, v := range doc {
+		path := prefix + k
+		if nested, ok := v.(map[string]interface{}); ok {
+			if _, mapped := types[path]; !mapped {
+				if coerceDoc(nested, types, path+".") {
+					changed = true
+				}
+				continue
+			}
+		}
+		t, ok := types[path]
+		if !ok {
+			continue
+		}
+		changed = true
+
+		if list, ok := v.([]interface{}); ok {
+			out := list[:0]
+			for _, e := range list {
+				if c, ok := coerceValue(e, t); ok {
+					out = append(out, c)
+				} else {
+					invalidTypedFields.Add(path, 1)
+				}
+			}
+			doc[k] = out
+			continue
+		}
+		if c, ok := coerceValue(v, t); ok {
+			doc[k] = c
+			continue
+		}
+		delete(doc, k)
+		invalidTypedFields.Add(path, 1)
+	}
+	return changed
+}
+
+// coerceValue converts v to a value ES accepts for a field of type t.
+// Types it doesn't know about are passed through.
+func coerceValue(v interface{}, t string) (interface{}, bool) {
+	if v == nil {
+		return nil, true
+	}
+	switch t {
+	case "long", "integer", "short", "byte":
+		// Exact integers would lose digits as floats.
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, true
+			}
+		}
+		f, ok := toFloat(v)
+		if !ok || f != math.Trunc(f) {
+			return nil, false
+		}
+		return int64(f), true
+	case "double", "float", "half_float", "scaled_float":
+		return toFloat(v)
+	case "boolean":
+		switch v := v.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			return b, err == nil
+		case float64:
+			return v != 0, v == 0 || v == 1
+		case json.Number:
+			f, err := v.Float64()
+			return f != 0, err == nil && (f == 0 || f == 1)
+		}
+		return nil, false
+	case "date":
+		if t, ok := parseEventTime(v); ok {
+			return t.UTC().Format(time.RFC3339Nano), true
+		}
+		return nil, false
+	case "ip":
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil {
+			return nil, false
+		}
+		return ip.String(), true
+	case "keyword", "text":
+		if s, ok := v.(string); ok {
+			return s, true
+		}
+		return scalarString(v)
+	}
+	return v, true
+}
+
+// toFloat converts a JSON number, or a string holding one, to a float.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs for on-disk files, chosen with -compression.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Magic numbers at the start of gzip members and zstd frames.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// validCompression reports whether codec is a known -compression value.
+func validCompression(codec string) bool {
+	switch codec {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// compressionExt returns the file name extension for codec.
+func compressionExt(codec string) string {
+	switch codec {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressWriter wraps w to compress with codec. Closing the returned
+// writer ends the gzip member or zstd frame but doesn't close w.
+func newCompressWriter(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("unknown compression %q", codec)
+}
+
+// newDecompressReader reads r, decompressing it if it starts with a gzip or
+// zstd header, so files can be read back whatever -compression was when
+// they were written. Concatenated members or frames read as one stream.
+func newDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return ioutil.NopCloser(br), nil
+}
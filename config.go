@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// The config file is a JSON object whose keys are flag names, e.g.
+//
+//	{"host": "broker.example.com", "parse-log-limit": 5}
+//
+// Values from the file are applied as if given on the command line, except
+// that flags given explicitly on the command line always win. Repeatable
+// flags take an array of values.
+
+// hotFlags are the settings that can be changed by editing the config file
+// and sending SIGHUP. Everything else requires a restart. Those from
+// rename to geohash-precision make up the hotSettings.
+var hotFlags = map[string]bool{
+	"parse-log-limit":    true,
+	"parse-log-interval": true,
+	"log-dedup-window":   true,
+	"threatintel-file":   true,
+
+	"rename":            true,
+	"rename-file":       true,
+	"app-alias":         true,
+	"app-alias-file":    true,
+	"transforms-file":   true,
+	"tag":               true,
+	"tag-precedence":    true,
+	"keep-fields":       true,
+	"transform":         true,
+	"geohash-precision": true,
+}
+
+// cliFlags records which flags were set on the command line, and
+// configFlags those set from the config file.
+var cliFlags, configFlags = map[string]bool{}, map[string]bool{}
+
+// readConfigFile reads the config file into a map of flag name to values.
+// Scalars become a single value and arrays one value per element.
+func readConfigFile(path string) (map[string][]string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]string)
+	for name, v := range raw {
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown setting %q", name)
+		}
+		var list []json.RawMessage
+		if json.Unmarshal(v, &list) == nil {
+			for _, e := range list {
+				values[name] = append(values[name], configValue(e))
+			}
+			continue
+		}
+		values[name] = []string{configValue(v)}
+	}
+	return values, nil
+}
+
+// configValue returns a config file value as flag text: strings unquoted,
+// anything else exactly as written, so numbers such as 1000000 aren't
+// turned into 1e+06 on the way.
+func configValue(v json.RawMessage) string {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	return string(bytes.TrimSpace(v))
+}
+
+// loadConfig applies the config file at startup. It must be called after
+// flag.Parse and cliFlags filled in.
+func loadConfig(path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for name, vs := range values {
+		if cliFlags[name] {
+			continue
+		}
+		for _, v := range vs {
+			if err := flag.Set(name, v); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		configFlags[name] = true
+	}
+	return nil
+}
+
+// reloadConfig re-reads the config file and applies any changed hot
+// settings to the running pipeline. A hot setting no longer in the file goes
+// back to its default, as it would on a restart. Changed settings that can't
+// be applied live are logged and otherwise ignored. If the new settings
+// don't make a valid pipeline, such as a -transform that doesn't compile,
+// none of them are applied.
+func reloadConfig(path string) {
+	values, err := readConfigFile(path)
+	if err != nil {
+		log.Printf("Error reloading config, keeping current settings: %v\n", err)
+		return
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := values[f.Name]; !ok && hotFlags[f.Name] {
+			values[f.Name] = defaultValues(f)
+		}
+	})
+
+	prev := make(map[string][]string) // Values of the settings changed.
+	for name, vs := range values {
+		if cliFlags[name] {
+			continue
+		}
+		f := flag.Lookup(name)
+		v, err := parseFlagValue(f, vs)
+		if err != nil {
+			log.Printf("Error applying %s: %v\n", name, err)
+			continue
+		}
+		if f.Value.String() == v {
+			continue
+		}
+		if !hotFlags[name] {
+			log.Printf("Setting %s changed, requires restart\n", name)
+			continue
+		}
+		if name == "threatintel-file" && (threatIntelProc == nil || v == "") {
+			log.Printf("Setting %s changed, requires restart\n", name)
+			continue
+		}
+		old := flagValues(f)
+		if err := setFlag(f, vs); err != nil {
+			log.Printf("Error applying %s: %v\n", name, err)
+			continue
+		}
+		prev[name] = old
+	}
+
+	if err := reloadSettings(); err != nil {
+		log.Printf("Error reloading settings, keeping current ones: %v\n", err)
+		for name, vs := range prev {
+			setFlag(flag.Lookup(name), vs)
+		}
+	} else {
+		for name := range prev {
+			log.Printf("Setting %s changed to %s\n", name, flag.Lookup(name).Value)
+		}
+	}
+
+	sampled := []*sampledLogger{parseLog, pluginLog, transformLog, fieldsLog}
+	for _, l := range sampled {
+		l.SetLimit(parseLogLimit, parseLogInterval)
+	}
+	for _, l := range append(sampled, bulkLog, channelLog) {
+		l.SetDedup(logDedupWindow)
+	}
+	if threatIntelProc != nil {
+		threatIntelProc.SetPath(threatFile)
+	}
+}
+
+// parseFlagValue parses vs into a new value of f's type and returns it as
+// text, the way f would show it once set, so an unchanged setting compares
+// equal however it's written: "1h" and "1h0m0s" are the same duration.
+func parseFlagValue(f *flag.Flag, vs []string) (string, error) {
+	v, ok := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+	if !ok {
+		return strings.Join(vs, ","), nil
+	}
+	for _, s := range vs {
+		if err := v.Set(s); err != nil {
+			return "", err
+		}
+	}
+	return v.String(), nil
+}
+
+// flagValues returns the current values of f, as setFlag takes them.
+func flagValues(f *flag.Flag) []string {
+	if list, ok := f.Value.(*stringList); ok {
+		return append([]string(nil), *list...)
+	}
+	return []string{f.Value.String()}
+}
+
+// defaultValues returns the default of f, as setFlag takes it.
+func defaultValues(f *flag.Flag) []string {
+	if _, ok := f.Value.(*stringList); ok {
+		return nil
+	}
+	return []string{f.DefValue}
+}
+
+// setFlag sets f to vs, replacing rather than adding to the values of a
+// repeatable flag.
+func setFlag(f *flag.Flag, vs []string) error {
+	if list, ok := f.Value.(*stringList); ok {
+		*list = nil
+	}
+	for _, v := range vs {
+		if err := f.Value.Set(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleSIGHUP reloads the config file, if any, and reloadable resources
+// every time the process receives a SIGHUP. Only this goroutine modifies hot
+// settings after startup; the components they feed guard their own state.
+// It returns on shutdown.
+func handleSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+		case <-shuttingDown:
+			return
+		}
+		log.Println("Received SIGHUP, reloading")
+		if configFile != "" {
+			reloadConfig(configFile)
+		} else if err := reloadSettings(); err != nil {
+			log.Printf("Error reloading settings, keeping current ones: %v\n", err)
+		}
+		if threatIntelProc != nil {
+			if err := threatIntelProc.Reload(); err != nil {
+				log.Printf("Error reloading threat intel: %v\n", err)
+			}
+		}
+		if asnProc != nil {
+			if err := asnProc.Reload(); err != nil {
+				log.Printf("Error reloading ASN database: %v\n", err)
+			}
+		}
+	}
+}
+
+// secretFlags are never shown in the effective configuration. URL flags
+// only have their password masked, see redactFlag.
+var secretFlags = map[string]bool{
+	"secret":          true,
+	"elastic-api-key": true,
+	"alert-webhook":   true, // Webhook URLs embed their token.
+}
+
+// redactFlag returns the value of f for display, with credentials masked.
+// Repeatable flags are returned as a list.
+func redactFlag(f *flag.Flag) interface{} {
+	v := f.Value.String()
+	if secretFlags[f.Name] && v != "" {
+		return "redacted"
+	}
+
+	list, ok := f.Value.(*stringList)
+	if !ok {
+		if f.Name == "elastic-url" || f.Name == "elastic-url-write" {
+			return redactURL(v)
+		}
+		return v
+	}
+	values := []string{}
+	for _, v := range *list {
+		switch f.Name {
+		case "broker":
+			v = redactURL(v)
+		case "elastic-header":
+			// Headers usually exist to carry credentials.
+			if k, _, ok := splitKeyValue(v); ok {
+				v = k + "=redacted"
+			}
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// redactURL masks the password in a URL, if it has one.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	return u.String()
+}
+
+// flagSource says where the current value of f came from.
+func flagSource(f *flag.Flag) string {
+	switch {
+	case cliFlags[f.Name]:
+		return "command line"
+	case configFlags[f.Name]:
+		return "config file"
+	default:
+		return "default"
+	}
+}
+
+// effectiveConfig returns every setting with its current value, credentials
+// masked, and where it came from, for /config.
+func effectiveConfig() map[string]interface{} {
+	cfg := map[string]interface{}{}
+	flag.VisitAll(func(f *flag.Flag) {
+		cfg[f.Name] = map[string]interface{}{
+			"value":  redactFlag(f),
+			"source": flagSource(f),
+		}
+	})
+	return cfg
+}
+
+// printConfig prints the settings that aren't at their defaults, credentials
+// masked. The full list is served at /config.
+func printConfig() {
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if flagSource(f) != "default" {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		f := flag.Lookup(name)
+		fmt.Printf("///- Config: -%s=%v (%s)\n", name, redactFlag(f), flagSource(f))
+	}
+}
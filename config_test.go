@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var defineConfigFlags sync.Once
+
+// writeConfig writes a config file for the test and returns its path. The
+// flags it may name are defined on first use, since main defines them.
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	defineConfigFlags.Do(func() {
+		flag.IntVar(&pauseBuffer, "pause-buffer", 100000, "")
+		flag.DurationVar(&parseLogInterval, "parse-log-interval", time.Minute, "")
+		flag.Var(&tagArgs, "tag", "")
+		flag.BoolVar(&flattenDocs, "flatten", false, "")
+	})
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		body string
+		want map[string][]string
+	}{
+		{`{"pause-buffer": 1000000}`, map[string][]string{"pause-buffer": {"1000000"}}},
+		{`{"pause-buffer": 12345678901}`, map[string][]string{"pause-buffer": {"12345678901"}}},
+		{`{"parse-log-interval": "1h"}`, map[string][]string{"parse-log-interval": {"1h"}}},
+		{`{"flatten": true}`, map[string][]string{"flatten": {"true"}}},
+		{`{"tag": ["a=1", "b=2"]}`, map[string][]string{"tag": {"a=1", "b=2"}}},
+	}
+	for _, tt := range tests {
+		got, err := readConfigFile(writeConfig(t, tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestReadConfigFileUnknown(t *testing.T) {
+	if _, err := readConfigFile(writeConfig(t, `{"no-such-flag": 1}`)); err == nil {
+		t.Error("unknown setting accepted")
+	}
+}
+
+func TestParseFlagValue(t *testing.T) {
+	writeConfig(t, `{}`) // Defines the flags.
+	tests := []struct {
+		name    string
+		current string
+		values  []string
+		same    bool
+	}{
+		{"parse-log-interval", "1h", []string{"1h"}, true},
+		{"parse-log-interval", "1h", []string{"60m"}, true},
+		{"parse-log-interval", "1h", []string{"2h"}, false},
+		{"pause-buffer", "1000000", []string{"1000000"}, true},
+		{"flatten", "false", []string{"false"}, true},
+		{"tag", "a=1", []string{"a=1"}, true},
+		{"tag", "a=1", []string{"a=1", "b=2"}, false},
+	}
+	for _, tt := range tests {
+		f := flag.Lookup(tt.name)
+		if err := setFlag(f, []string{tt.current}); err != nil {
+			t.Fatal(err)
+		}
+		v, err := parseFlagValue(f, tt.values)
+		if err != nil {
+			t.Errorf("%s %v: %v", tt.name, tt.values, err)
+			continue
+		}
+		if same := f.Value.String() == v; same != tt.same {
+			t.Errorf("%s: %q vs %v: same = %v, want %v", tt.name, tt.current, tt.values, same, tt.same)
+		}
+	}
+}
+
+func TestParseFlagValueInvalid(t *testing.T) {
+	writeConfig(t, `{}`)
+	if _, err := parseFlagValue(flag.Lookup("parse-log-interval"), []string{"soon"}); err == nil {
+		t.Error("invalid duration accepted")
+	}
+}
+
+func TestReloadConfigResetsRemovedSettings(t *testing.T) {
+	withHotFlags(t, "", "")
+	loggers := []**sampledLogger{&parseLog, &pluginLog, &transformLog, &fieldsLog, &bulkLog, &channelLog}
+	for _, l := range loggers {
+		l := l
+		old := *l
+		t.Cleanup(func() { *l = old })
+		*l = newSampledLogger(0, 0, 0)
+	}
+	oldInterval := parseLogInterval
+	defer func() { parseLogInterval = oldInterval }()
+
+	path := writeConfig(t, `{"parse-log-interval": "1h", "tag": ["env=prod"]}`)
+	parseLogInterval = time.Minute
+	reloadConfig(path)
+	if parseLogInterval != time.Hour || currentSettings().tags["env"] != "prod" {
+		t.Fatalf("config not applied: parse-log-interval %s, tags %v", parseLogInterval, currentSettings().tags)
+	}
+
+	// Removing the keys restores the defaults.
+	if err := ioutil.WriteFile(path, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig(path)
+	if parseLogInterval != time.Minute {
+		t.Errorf("parse-log-interval = %s after removing it, want the default 1m0s", parseLogInterval)
+	}
+	if len(tagArgs) != 0 || len(currentSettings().tags) != 0 {
+		t.Errorf("tags = %v after removing them, want none", currentSettings().tags)
+	}
+}
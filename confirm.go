@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// confirm asks the operator to type "yes" before going ahead with something
+// destructive. -force skips the prompt for automation.
+func confirm(prompt string) bool {
+	if force {
+		return true
+	}
+
+	fmt.Printf("%s Type \"yes\" to continue: ", prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(line) == "yes"
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// FallbackSuffix is added to an index name to get its -conflict-fallback
+// index.
+const FallbackSuffix = "-raw"
+
+// fallbackMapping keeps the whole rejected document in a single flattened
+// field, which takes any JSON object without mapping its fields, so nothing
+// in it can conflict again.
+const fallbackMapping = `{
+	"mappings": {
+		"dynamic": false,
+		"properties": {
+			"doc": {"type": "flattened"},
+			"error": {"type": "text"},
+			"index": {"type": "keyword"},
+			"timestamp": {"type": "date"}
+		}
+	}
+}`
+
+// fallbackIndexes records the fallback indexes known to exist.
+var fallbackIndexes sync.Map
+
+// mappingConflict reports whether a bulk item failed because a field didn't
+// fit the index mapping.
+func mappingConflict(errType string) bool {
+	return errType == "mapper_parsing_exception" || errType == "document_parsing_exception"
+}
+
+// fallbackRequest wraps the document of a request rejected by a mapping
+// conflict for the fallback index of the index it was meant for. It returns
+// false for requests that were already going to a fallback index, or that
+// can't be decoded.
+func fallbackRequest(client *elastic.Client, f failedRequest) (elastic.BulkableRequest, bool) {
+	lines, err := f.req.Source()
+	if err != nil || len(lines) < 2 {
+		return nil, false
+	}
+	var action map[string]struct {
+		Index string `json:"_index"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &action); err != nil {
+		return nil, false
+	}
+	var index string
+	for _, meta := range action {
+		index = meta.Index
+	}
+	if index == "" || strings.HasSuffix(strings.TrimSuffix(index, ">"), FallbackSuffix) {
+		return nil, false
+	}
+
+	fallback := index + FallbackSuffix
+	if strings.HasPrefix(index, "<") && strings.HasSuffix(index, ">") {
+		// Keep a date math name intact: <name-{now/d}-raw>.
+		fallback = strings.TrimSuffix(index, ">") + FallbackSuffix + ">"
+	}
+	if !ensureFallbackIndex(client, fallback) {
+		return nil, false
+	}
+	return newBulkIndexRequest().Index(fallback).Doc(map[string]interface{}{
+		"doc":       json.RawMessage(lines[1]),
+		"error":     f.reason,
+		"index":     index,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}), true
+}
+
+// ensureFallbackIndex creates a fallback index the first time it's needed.
+func ensureFallbackIndex(client *elastic.Client, index string) bool {
+	if _, ok := fallbackIndexes.Load(index); ok {
+		return true
+	}
+	_, err := client.CreateIndex(index).Body(fallbackMapping).Do(context.Background())
+	if err != nil && !indexExists(err) {
+		log.Printf("Error creating fallback index %s: %v\n", index, err)
+		return false
+	}
+	if err == nil {
+		log.Printf("Created fallback index %s\n", index)
+	}
+	fallbackIndexes.Store(index, true)
+	return true
+}
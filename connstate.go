@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hpfeeds connection states.
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+)
+
+// Subscription states. hpfeeds doesn't acknowledge subscribes, and a
+// refused one only gets an error the client library logs, so a channel
+// counts as active once a message arrives on it.
+const (
+	SubPending     = "pending"     // Subscribe sent, nothing received yet.
+	SubActive      = "active"      // Messages received since subscribing.
+	SubUnconfirmed = "unconfirmed" // Nothing within -subscribe-timeout.
+)
+
+// connState tracks the hpfeeds connection for logging and the health
+// endpoints. Every transition is logged as a single key=value line so
+// flapping connections are easy to alert on.
+type connState struct {
+	mu       sync.Mutex
+	broker   string
+	channels []string
+	state    string
+	since    time.Time
+	attempt  int
+	lastErr  string
+	subs     map[string]string // Channel -> subscription state.
+}
+
+// newConnState returns the state of a not yet connected broker.
+func newConnState(broker string, channels []string) *connState {
+	return &connState{
+		broker:   broker,
+		channels: channels,
+		state:    StateDisconnected,
+		since:    time.Now(),
+		subs:     make(map[string]string),
+	}
+}
+
+// Connecting records a connection attempt.
+func (c *connState) Connecting(attempt int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.attempt = attempt
+	c.transition(StateConnecting, fmt.Sprintf("attempt=%d", attempt))
+}
+
+// Connected records a successful connection.
+func (c *connState) Connected() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.transition(StateConnected, fmt.Sprintf("attempt=%d", c.attempt))
+}
+
+// Disconnected records a lost or failed connection and why, if known.
+func (c *connState) Disconnected(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reason := "unknown"
+	if err != nil {
+		reason = err.Error()
+	}
+	c.lastErr = reason
+	c.subs = make(map[string]string)
+	fields := fmt.Sprintf("reason=%q", reason)
+	if c.state == StateConnected {
+		fields = fmt.Sprintf("connected_for=%s %s", time.Since(c.since).Round(time.Second), fields)
+	}
+	c.transition(StateDisconnected, fields)
+}
+
+// Subscribed records that subscribes were sent for every channel.
+func (c *connState) Subscribed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ch := range c.channels {
+		c.subs[ch] = SubPending
+	}
+}
+
+// Delivered records a message on channel, confirming the subscription.
+func (c *connState) Delivered(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subs[channel] != SubActive {
+		c.subs[channel] = SubActive
+	}
+}
+
+// Unconfirm marks the channels still pending as unconfirmed and returns
+// them.
+func (c *connState) Unconfirm() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+	for _, ch := range c.channels {
+		if c.subs[ch] == SubPending {
+			c.subs[ch] = SubUnconfirmed
+			names = append(names, ch)
+		}
+	}
+	return names
+}
+
+// Subscriptions returns the state of every channel's subscription.
+func (c *connState) Subscriptions() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs := make(map[string]string, len(c.channels))
+	for _, ch := range c.channels {
+		if state, ok := c.subs[ch]; ok {
+			subs[ch] = state
+		}
+	}
+	return subs
+}
+
+func (c *connState) transition(state, fields string) {
+	c.state = state
+	c.since = time.Now()
+	log.Printf("hpfeeds state=%s broker=%s channels=%s %s\n",
+		state, c.broker, strings.Join(c.channels, ","), fields)
+}
+
+// Status returns the connection state for the status endpoint.
+func (c *connState) Status() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs := make(map[string]string, len(c.subs))
+	for ch, state := range c.subs {
+		subs[ch] = state
+	}
+	return map[string]interface{}{
+		"state":         c.state,
+		"since":         c.since.UTC().Format(time.RFC3339),
+		"broker":        c.broker,
+		"channels":      c.channels,
+		"subscriptions": subs,
+		"attempt":       c.attempt,
+		"last_error":    c.lastErr,
+	}
+}
+
+// IsConnected reports whether the hpfeeds connection is up.
+func (c *connState) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state == StateConnected
+}
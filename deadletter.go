@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// deadLetterRecord is one line of the dead letter file. Action and Doc are
+// the two lines the record would have contributed to a bulk request, so it
+// can be replayed as is.
+type deadLetterRecord struct {
+	Time   string          `json:"time"`
+	Reason string          `json:"reason"`
+	Action json.RawMessage `json:"action"`
+	Doc    json.RawMessage `json:"doc"`
+}
+
+var (
+	deadLetterMu   sync.Mutex
+	deadLetterFile *os.File
+)
+
+// openDeadLetter opens the NDJSON file undeliverable documents are appended
+// to.
+func openDeadLetter(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	deadLetterFile = f
+	return nil
+}
+
+// deadLetter records a request that couldn't be delivered. Without
+// -dead-letter-file it is only counted.
+func deadLetter(req elastic.BulkableRequest, reason string) {
+	deadLettered.Add(1)
+	if deadLetterFile == nil {
+		return
+	}
+
+	lines, err := req.Source()
+	if err != nil || len(lines) < 2 {
+		log.Printf("Error serializing dead letter: %v\n", err)
+		return
+	}
+	buf, err := json.Marshal(deadLetterRecord{
+		Time:   time.Now().UTC().Format(time.RFC3339),
+		Reason: reason,
+		Action: json.RawMessage(lines[0]),
+		Doc:    json.RawMessage(lines[1]),
+	})
+	if err != nil {
+		log.Printf("Error serializing dead letter: %v\n", err)
+		return
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	if _, err := deadLetterFile.Write(append(buf, '\n')); err != nil {
+		log.Printf("Error writing dead letter: %v\n", err)
+	}
+}
+
+func deadLetterAll(reqs []elastic.BulkableRequest, reason string) {
+	for _, req := range reqs {
+		deadLetter(req, reason)
+	}
+}
+
+// closeDeadLetter closes the dead letter file, if one is open.
+func closeDeadLetter() {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	if deadLetterFile == nil {
+		return
+	}
+	if err := deadLetterFile.Close(); err != nil {
+		log.Printf("Error closing dead letter file: %v\n", err)
+	}
+	deadLetterFile = nil
+}
+
+// rawBulkRequest is a bulk request replayed from its serialized action and
+// document lines.
+type rawBulkRequest struct {
+	action, doc json.RawMessage
+}
+
+func (r rawBulkRequest) String() string {
+	return string(r.action) + "\n" + string(r.doc)
+}
+
+func (r rawBulkRequest) Source() ([]string, error) {
+	return []string{string(r.action), string(r.doc)}, nil
+}
+
+// replayFailedPath returns the dead letter file for records that still fail
+// when replaying -replay-dead-letter: -dead-letter-file, or next to the
+// replayed file with a .failed suffix so they aren't lost.
+func replayFailedPath() string {
+	if deadLetterPath != "" {
+		return deadLetterPath
+	}
+	return replayDeadPath + ".failed"
+}
+
+// replayDeadLetter sends the records of the dead letter file at path to ES
+// again, batch at a time, e.g. after fixing the mapping that rejected them.
+// Records that still fail go through the usual retries and on to the
+// current dead letter file, which must not be path. It returns how many
+// records were read and how many of those are now indexed.
+func replayDeadLetter(client *elastic.Client, path string, batch int) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	failedBefore := deadLettered.Value()
+	total, skipped := 0, 0
+	var reqs []elastic.BulkableRequest
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec deadLetterRecord
+			if jerr := json.Unmarshal(line, &rec); jerr != nil || len(rec.Action) == 0 || len(rec.Doc) == 0 {
+				skipped++
+			} else {
+				reqs = append(reqs, rawBulkRequest{rec.Action, rec.Doc})
+				total++
+			}
+		}
+		if len(reqs) >= batch || (err != nil && len(reqs) > 0) {
+			flushBulk(client, reqs)
+			reqs = nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, total - int(deadLettered.Value()-failedBefore), err
+		}
+	}
+
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed dead letter records\n", skipped)
+	}
+	return total, total - int(deadLettered.Value()-failedBefore), nil
+}
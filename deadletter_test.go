@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayDeadLetterKeepsFailures(t *testing.T) {
+	oldReplay, oldDead := replayDeadPath, deadLetterPath
+	defer func() { replayDeadPath, deadLetterPath = oldReplay, oldDead }()
+	replayDeadPath = filepath.Join(t.TempDir(), "dead.ndjson")
+	deadLetterPath = ""
+
+	records := `{"action": {"index": {"_index": "test", "_id": "0"}}, "doc": {"n": 0}}
+{"action": {"index": {"_index": "test", "_id": "1"}}, "doc": {"n": "one"}}
+`
+	if err := os.WriteFile(replayDeadPath, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := fakeBulkClient(t, `{"took": 1, "errors": true, "items": [
+		{"index": {"_index": "test", "_id": "0", "status": 201}},
+		{"index": {"_index": "test", "_id": "1", "status": 400, "error": {"type": "mapper_parsing_exception", "reason": "failed to parse field [n]"}}}
+	]}`)
+
+	if err := openDeadLetter(replayFailedPath()); err != nil {
+		t.Fatal(err)
+	}
+	total, recovered, err := replayDeadLetter(client, replayDeadPath, 10)
+	closeDeadLetter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || recovered != 1 {
+		t.Errorf("recovered %d of %d, want 1 of 2", recovered, total)
+	}
+
+	f, err := os.Open(replayDeadPath + ".failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for s := bufio.NewScanner(f); s.Scan(); {
+		lines++
+	}
+	if lines != 1 {
+		t.Errorf("%s has %d records, want the 1 that still failed", f.Name(), lines)
+	}
+}
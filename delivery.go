@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hpfeeds has no acknowledgements or replay: the broker forgets a message
+// as soon as it has sent it. What we can do is account for every message
+// between receiving it and ES accepting it, so the loss window on a crash is
+// known rather than guessed.
+var (
+	// unflushed is the number of documents buffered in processPayloads or
+	// in a bulk request that hasn't completed, including its retries.
+	unflushed int64
+
+	// lastFlush is when the last bulk request completed, in unix
+	// nanoseconds, or 0 before the first one.
+	lastFlush int64
+
+	// lastSuccess is when ES last accepted a bulk request, in unix
+	// nanoseconds, or 0 before the first one.
+	lastSuccess int64
+
+	// bufferFull is 1 while documents wait for room under
+	// -max-buffered-docs.
+	bufferFull int32
+)
+
+// lastError is the most recent reason a bulk request or item failed, for
+// /status and alerts.
+var lastError struct {
+	sync.Mutex
+	reason string
+	time   time.Time
+}
+
+func addUnflushed(n int) {
+	atomic.AddInt64(&unflushed, int64(n))
+}
+
+// flushed records that a batch of n documents was handed off, either to ES
+// or to the dead letter file.
+func flushed(n int) {
+	atomic.AddInt64(&unflushed, -int64(n))
+	atomic.StoreInt64(&lastFlush, time.Now().UnixNano())
+}
+
+// setBufferFull records whether documents are waiting for room under
+// -max-buffered-docs.
+func setBufferFull(full bool) {
+	var v int32
+	if full {
+		v = 1
+	}
+	atomic.StoreInt32(&bufferFull, v)
+}
+
+// flushSucceeded records that ES accepted a bulk request.
+func flushSucceeded() {
+	atomic.StoreInt64(&lastSuccess, time.Now().UnixNano())
+}
+
+// flushFailed records why a bulk request or some of its items failed.
+func flushFailed(reason string) {
+	lastError.Lock()
+	lastError.reason = reason
+	lastError.time = time.Now()
+	lastError.Unlock()
+}
+
+// lastFlushError returns the last failure reason and when it happened, or
+// "" if nothing has failed yet.
+func lastFlushError() (string, time.Time) {
+	lastError.Lock()
+	defer lastError.Unlock()
+	return lastError.reason, lastError.time
+}
+
+func unflushedDocs() int64 {
+	return atomic.LoadInt64(&unflushed)
+}
+
+// deliveryStatus returns the delivery accounting for /status.
+func deliveryStatus() map[string]interface{} {
+	status := map[string]interface{}{
+		"unflushed_docs": unflushedDocs(),
+		"buffer_full":    atomic.LoadInt32(&bufferFull) == 1,
+	}
+	if maxBufferedDocs > 0 {
+		status["max_buffered_docs"] = maxBufferedDocs
+	}
+	if t := atomic.LoadInt64(&lastFlush); t > 0 {
+		status["last_flush"] = time.Unix(0, t).UTC().Format(time.RFC3339)
+	}
+	if t := atomic.LoadInt64(&lastSuccess); t > 0 {
+		status["last_success"] = time.Unix(0, t).UTC().Format(time.RFC3339)
+	}
+	if reason, t := lastFlushError(); reason != "" {
+		status["last_error"] = reason
+		status["last_error_time"] = t.UTC().Format(time.RFC3339)
+	}
+	return status
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// ECSVersion is the version of the Elastic Common Schema that -ecs
+// documents follow.
+const ECSVersion = "1.12.0"
+
+// ECSCustomField is where the honeypot fields without an ECS equivalent end
+// up in -ecs documents.
+const ECSCustomField = "honeypot"
+
+// ecsFields maps honeypot fields to the ECS fields they become.
+var ecsFields = map[string]string{
+	"src_ip":     "source.ip",
+	"src_port":   "source.port",
+	"dest_ip":    "destination.ip",
+	"dest_port":  "destination.port",
+	"transport":  "network.transport",
+	"protocol":   "network.protocol",
+	"signature":  "rule.name",
+	"username":   "user.name",
+	"url":        "url.original",
+	"user_agent": "user_agent.original",
+	"sensor":     "observer.name",
+}
+
+// ecsDropped are fields the ECS document carries in another form.
+var ecsDropped = []string{
+	"app", "timestamp", "hpfeeds_broker", "ingest_lag_seconds", "ingest_lag_ms",
+	"src_location", "dest_location",
+	"src_latitude", "src_longitude", "dest_latitude", "dest_longitude",
+	"src_geohash", "dest_geohash",
+}
+
+// toECS restructures an enriched document into ECS. eventTime is the time
+// the honeypot reported for the event, if hasEventTime, and becomes
+// @timestamp; otherwise the ingest time is used. Fields without an ECS
+// equivalent are kept under ECSCustomField.
+func toECS(doc map[string]interface{}, p *Payload, mes message, eventTime time.Time, hasEventTime bool) map[string]interface{} {
+	now := time.Now().UTC()
+	if !hasEventTime {
+		eventTime = now
+	}
+
+	out := map[string]interface{}{
+		"@timestamp": eventTime.UTC().Format(time.RFC3339Nano),
+		"ecs":        map[string]interface{}{"version": ECSVersion},
+		"event": map[string]interface{}{
+			"kind":     "event",
+			"category": []interface{}{"intrusion_detection", "network"},
+			"module":   p.App,
+			"dataset":  mes.Channel,
+			"ingested": now.Format(time.RFC3339Nano),
+		},
+		"observer": map[string]interface{}{
+			"type":    "honeypot",
+			"product": p.App,
+		},
+	}
+
+	hp := map[string]interface{}{"channel": mes.Channel}
+	if mes.Broker != "" {
+		hp["broker"] = mes.Broker
+	}
+	if includeProvenance {
+		hp = provenance(mes)
+		delete(doc, ProvenanceField)
+	}
+	for _, f := range []string{"ingest_lag_seconds", "ingest_lag_ms"} {
+		if lag, ok := doc[f]; ok {
+			hp[f] = lag
+		}
+	}
+	out["hpfeeds"] = hp
+
+	if validCoords(p.SrcLatitude, p.SrcLongitude) {
+		setPath(out, "source.geo.location", map[string]interface{}{"lat": p.SrcLatitude, "lon": p.SrcLongitude})
+	}
+	if validCoords(p.DestLatitude, p.DestLongitude) {
+		setPath(out, "destination.geo.location", map[string]interface{}{"lat": p.DestLatitude, "lon": p.DestLongitude})
+	}
+
+	for _, k := range ecsDropped {
+		delete(doc, k)
+	}
+	for k, v := range doc {
+		if field, ok := ecsFields[k]; ok {
+			setPath(out, field, v)
+			delete(doc, k)
+		}
+	}
+	if len(doc) > 0 {
+		out[ECSCustomField] = doc
+	}
+	return out
+}
+
+// setPath sets the dotted path field in doc to v, creating the objects on
+// the way as needed.
+func setPath(doc map[string]interface{}, field string, v interface{}) {
+	parts := strings.Split(field, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			doc[part] = next
+		}
+		doc = next
+	}
+	doc[parts[len(parts)-1]] = v
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// UserAgent identifies the ingester in ES audit and slow logs.
+const UserAgent = "hpfeeds-elastic/" + Version
+
+// headerTransport sets fixed headers on every request before handing it to
+// the underlying transport.
+type headerTransport struct {
+	headers http.Header
+	next    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header[k] = v
+	}
+	return t.next.RoundTrip(req)
+}
+
+// parseHeaders parses -elastic-header key=value arguments.
+func parseHeaders(args []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, arg := range args {
+		k, v, ok := splitKeyValue(arg)
+		if !ok || !validHeaderName(k) {
+			return nil, fmt.Errorf("invalid header %q, want key=value", arg)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("invalid header %q, value contains a newline", arg)
+		}
+		headers.Add(k, v)
+	}
+	return headers, nil
+}
+
+// validHeaderName reports whether name only uses HTTP token characters.
+func validHeaderName(name string) bool {
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// decodeCloudID turns an Elastic Cloud ID, "<name>:<base64 data>", into the
+// URL of the deployment's ES endpoint. The data decodes to
+// "<host>[:port]$<es id>$<kibana id>".
+func decodeCloudID(cloudID string) (string, error) {
+	i := strings.LastIndex(cloudID, ":")
+	if i < 0 {
+		return "", fmt.Errorf("malformed cloud id: missing ':'")
+	}
+	data, err := base64.StdEncoding.DecodeString(cloudID[i+1:])
+	if err != nil {
+		return "", fmt.Errorf("malformed cloud id: %v", err)
+	}
+	parts := strings.Split(string(data), "$")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("malformed cloud id: want host$es-id$kibana-id")
+	}
+
+	host, port := parts[0], "443"
+	if j := strings.LastIndex(host, ":"); j >= 0 {
+		host, port = host[:j], host[j+1:]
+	}
+	return fmt.Sprintf("https://%s.%s:%s", parts[1], host, port), nil
+}
+
+// newTransport returns the HTTP transport for ES requests, tuned by
+// -es-max-idle-conns, -es-dial-timeout and -es-response-timeout. The stock
+// transport keeps only 2 idle connections per host, which throttles a busy
+// ingester talking to a distant cluster to a couple of reused connections.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = esMaxIdleConns
+	t.MaxIdleConnsPerHost = esMaxIdleConns
+	t.DialContext = (&net.Dialer{
+		Timeout:   esDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	t.ResponseHeaderTimeout = esResponseTimeout
+	return t
+}
+
+// newElasticClient creates the ES client from the command line settings.
+// Headers given with -elastic-header are sent with every request and take
+// precedence over the defaults. -cloud-id, when set, takes precedence over
+// -elastic-url. Nodes are sniffed with -es-sniff, except on Elastic Cloud.
+// Any options given are applied last.
+func newElasticClient(opts ...elastic.ClientOptionFunc) (*elastic.Client, error) {
+	url := elasticURL
+	sniff := esSniff
+	if cloudID != "" {
+		var err error
+		if url, err = decodeCloudID(cloudID); err != nil {
+			return nil, err
+		}
+		// Cloud deployments sit behind a proxy; the node addresses
+		// sniffing would discover aren't reachable.
+		sniff = false
+	}
+
+	headers := http.Header{}
+	headers.Set("User-Agent", UserAgent)
+	if opaqueID != "" {
+		headers.Set("X-Opaque-Id", opaqueID)
+	}
+	if apiKey != "" {
+		headers.Set("Authorization", "ApiKey "+apiKey)
+	}
+	custom, err := parseHeaders(elasticHeaders)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range custom {
+		headers[k] = v
+	}
+	httpClient := &http.Client{
+		Transport: &headerTransport{headers, newTransport()},
+	}
+
+	return elastic.NewClient(append([]elastic.ClientOptionFunc{
+		elastic.SetURL(url),
+		elastic.SetSniff(sniff),
+		elastic.SetHttpClient(httpClient),
+	}, opts...)...)
+}
+
+// newWriteClient creates the ES client for bulk writes, which is the
+// -elastic-url-write endpoint if set and otherwise the same as
+// newElasticClient.
+func newWriteClient() (*elastic.Client, error) {
+	if writeURL == "" {
+		return newElasticClient()
+	}
+	return newElasticClient(elastic.SetURL(writeURL))
+}
@@ -0,0 +1,31 @@
+// Command tagger is an example hpfeeds-elastic plugin that tags documents
+// whose src_ip appears in a small list of known-bad addresses.
+//
+// Build it with:
+//
+//	go build -buildmode=plugin -o tagger.so ./examples/plugins/tagger
+//
+// and place tagger.so in the directory passed to -plugin-dir.
+package main
+
+// knownBad would normally be loaded from a threat-intel feed.
+var knownBad = map[string]bool{
+	"198.51.100.7": true,
+	"203.0.113.42": true,
+}
+
+type tagger struct{}
+
+// Process adds threat_tagged: true to any document from a known-bad source.
+func (tagger) Process(doc map[string]interface{}) (map[string]interface{}, error) {
+	if ip, ok := doc["src_ip"].(string); ok && knownBad[ip] {
+		doc["threat_tagged"] = true
+	}
+	return doc, nil
+}
+
+// Processor is looked up by hpfeeds-elastic when the plugin is loaded.
+var Processor tagger
+
+// main is required to build as a regular package but is unused as a plugin.
+func main() {}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// injectedFields are added to every document by the ingester and are never
+// removed by field filtering.
+var injectedFields = []string{"src_location", "dest_location", "timestamp", "hpfeeds_broker", "sensor", "has_geo", ProvenanceField}
+
+// fieldSet is a tree of dotted field paths. A nil subtree means the whole
+// field, including anything nested under it, is selected.
+type fieldSet map[string]fieldSet
+
+// parseFieldSet builds a fieldSet from a comma separated list of dotted
+// paths such as "src_ip,connection.protocol".
+func parseFieldSet(list string) fieldSet {
+	set := fieldSet{}
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			set.add(path)
+		}
+	}
+	return set
+}
+
+func (s fieldSet) add(path string) {
+	parts := strings.Split(path, ".")
+	cur := s
+	for i, p := range parts {
+		sub, ok := cur[p]
+		if ok && sub == nil {
+			// Already selecting the whole subtree.
+			return
+		}
+		if i == len(parts)-1 {
+			cur[p] = nil
+			return
+		}
+		if !ok {
+			sub = fieldSet{}
+			cur[p] = sub
+		}
+		cur = sub
+	}
+}
+
+// keepFields removes every field from doc that isn't in keep. Nested objects
+// are pruned recursively, and objects left empty by pruning are dropped.
+func keepFields(doc map[string]interface{}, keep fieldSet) {
+	for k, v := range doc {
+		sub, ok := keep[k]
+		if !ok {
+			delete(doc, k)
+			continue
+		}
+		if sub == nil {
+			continue
+		}
+		nested, isMap := v.(map[string]interface{})
+		if !isMap {
+			// Asked for a child of something that isn't an object.
+			delete(doc, k)
+			continue
+		}
+		keepFields(nested, sub)
+		if len(nested) == 0 {
+			delete(doc, k)
+		}
+	}
+}
+
+// dropFields removes every field in drop from doc, the inverse of
+// keepFields, and reports whether there was any. Objects left empty are
+// kept.
+func dropFields(doc map[string]interface{}, drop fieldSet) bool {
+	dropped := false
+	for k, sub := range drop {
+		if sub == nil {
+			if _, ok := doc[k]; ok {
+				delete(doc, k)
+				dropped = true
+			}
+			continue
+		}
+		if nested, ok := doc[k].(map[string]interface{}); ok && dropFields(nested, sub) {
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+// lookupField returns the value at a dotted path in doc. A literal key
+// containing dots takes precedence over walking nested objects.
+func lookupField(doc map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := doc[path]; ok {
+		return v, true
+	}
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) < 2 {
+		return nil, false
+	}
+	nested, ok := doc[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupField(nested, parts[1])
+}
+
+// flatten replaces nested objects in doc with dotted keys, so
+// {"connection": {"protocol": "tcp"}} becomes {"connection.protocol": "tcp"}.
+// Objects deeper than maxDepth levels are stored as a JSON string under their
+// flattened parent key; a maxDepth of 0 or less flattens everything. Arrays
+// of scalars are kept as they are, while arrays holding objects are stored
+// as a JSON string too, since ES would map every key inside them.
+func flatten(doc map[string]interface{}, maxDepth int) map[string]interface{} {
+	out := make(map[string]interface{}, len(doc))
+	flattenInto(out, "", doc, 1, maxDepth)
+	return out
+}
+
+func flattenInto(out map[string]interface{}, prefix string, doc map[string]interface{}, depth, maxDepth int) {
+	for k, v := range doc {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if maxDepth > 0 && depth > maxDepth {
+				out[key] = jsonString(v)
+				continue
+			}
+			flattenInto(out, key+".", v, depth+1, maxDepth)
+		case []interface{}:
+			if hasObjects(v) {
+				out[key] = jsonString(v)
+				continue
+			}
+			out[key] = v
+		default:
+			out[key] = v
+		}
+	}
+}
+
+// hasObjects reports whether list holds any objects or nested arrays.
+func hasObjects(list []interface{}) bool {
+	for _, v := range list {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return true
+		}
+	}
+	return false
+}
+
+// jsonString encodes v as a JSON string value for a flattened document.
+func jsonString(v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(buf)
+}
+
+// scalarString formats a scalar JSON value as a string. Objects, arrays and
+// nulls aren't scalars and return false.
+func scalarString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, v != ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case json.Number:
+		return v.String(), true
+	case int, int32, int64, uint, uint32, uint64:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// versionNumber returns v as an ES external version: a non-negative integer,
+// given as a number or a numeric string.
+func versionNumber(v interface{}) (int64, bool) {
+	var n int64
+	switch v := v.(type) {
+	case float64:
+		if v != math.Trunc(v) || v >= math.MaxInt64 {
+			return 0, false
+		}
+		n = int64(v)
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return 0, false
+		}
+		n = i
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		n = i
+	default:
+		return 0, false
+	}
+	return n, n >= 0
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// dionaeaConnection is a connection event as dionaea publishes it on
+// dionaea.connections.
+const dionaeaConnection = `{
+	"app": "dionaea",
+	"connection": {
+		"type": "accept",
+		"transport": "tcp",
+		"protocol": "smbd",
+		"local": {"address": "10.0.0.5", "port": 445},
+		"remote": {"address": "192.0.2.77", "port": 49152, "hostname": ""}
+	},
+	"timestamp": "2024-01-31T12:00:00.000000"
+}`
+
+func mustDecode(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := unmarshalDoc([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestFlattenDionaeaConnection(t *testing.T) {
+	want := map[string]interface{}{
+		"app":                        "dionaea",
+		"connection.type":            "accept",
+		"connection.transport":       "tcp",
+		"connection.protocol":        "smbd",
+		"connection.local.address":   "10.0.0.5",
+		"connection.local.port":      json.Number("445"),
+		"connection.remote.address":  "192.0.2.77",
+		"connection.remote.port":     json.Number("49152"),
+		"connection.remote.hostname": "",
+		"timestamp":                  "2024-01-31T12:00:00.000000",
+	}
+	got := flatten(mustDecode(t, dionaeaConnection), 0)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestFlattenDepthAndArrays(t *testing.T) {
+	const nested = `{
+		"a": {"b": {"c": {"d": 1}}},
+		"ports": [22, 23],
+		"creds": [{"user": "root", "pass": "admin"}],
+		"matrix": [[1, 2], [3]],
+		"empty": {},
+		"none": null
+	}`
+	tests := []struct {
+		name     string
+		maxDepth int
+		want     map[string]interface{}
+	}{
+		{"unlimited", 0, map[string]interface{}{
+			"a.b.c.d": json.Number("1"),
+			"ports":   []interface{}{json.Number("22"), json.Number("23")},
+			"creds":   `[{"pass":"admin","user":"root"}]`,
+			"matrix":  `[[1,2],[3]]`,
+			"none":    nil,
+		}},
+		{"depth 1", 1, map[string]interface{}{
+			"a.b":    `{"c":{"d":1}}`,
+			"ports":  []interface{}{json.Number("22"), json.Number("23")},
+			"creds":  `[{"pass":"admin","user":"root"}]`,
+			"matrix": `[[1,2],[3]]`,
+			"none":   nil,
+		}},
+		{"depth 2", 2, map[string]interface{}{
+			"a.b.c":  `{"d":1}`,
+			"ports":  []interface{}{json.Number("22"), json.Number("23")},
+			"creds":  `[{"pass":"admin","user":"root"}]`,
+			"matrix": `[[1,2],[3]]`,
+			"none":   nil,
+		}},
+		{"deeper than the document", 10, map[string]interface{}{
+			"a.b.c.d": json.Number("1"),
+			"ports":   []interface{}{json.Number("22"), json.Number("23")},
+			"creds":   `[{"pass":"admin","user":"root"}]`,
+			"matrix":  `[[1,2],[3]]`,
+			"none":    nil,
+		}},
+	}
+	for _, tt := range tests {
+		got := flatten(mustDecode(t, nested), tt.maxDepth)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v\nwant %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKeepFieldsNested(t *testing.T) {
+	tests := []struct {
+		name string
+		keep string
+		want map[string]interface{}
+	}{
+		{"top level", "app,src_ip", map[string]interface{}{
+			"app": "dionaea", "src_ip": "192.0.2.77"}},
+		{"whole object", "connection", map[string]interface{}{
+			"connection": map[string]interface{}{
+				"protocol": "smbd",
+				"local":    map[string]interface{}{"port": json.Number("445")},
+				"remote":   map[string]interface{}{"address": "192.0.2.77"}}}},
+		{"nested leaves", "connection.protocol,connection.local.port", map[string]interface{}{
+			"connection": map[string]interface{}{
+				"protocol": "smbd",
+				"local":    map[string]interface{}{"port": json.Number("445")}}}},
+		{"object and a leaf inside it", "connection.local,connection.local.port", map[string]interface{}{
+			"connection": map[string]interface{}{
+				"local": map[string]interface{}{"port": json.Number("445")}}}},
+		{"emptied objects dropped", "app,connection.missing", map[string]interface{}{
+			"app": "dionaea"}},
+		{"child of a scalar", "app.name", map[string]interface{}{}},
+	}
+	const in = `{
+		"app": "dionaea",
+		"src_ip": "192.0.2.77",
+		"connection": {
+			"protocol": "smbd",
+			"local": {"port": 445},
+			"remote": {"address": "192.0.2.77"}
+		}
+	}`
+	for _, tt := range tests {
+		doc := mustDecode(t, in)
+		keepFields(doc, parseFieldSet(tt.keep))
+		if !reflect.DeepEqual(doc, tt.want) {
+			t.Errorf("%s: got %v\nwant %v", tt.name, doc, tt.want)
+		}
+	}
+}
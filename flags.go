@@ -0,0 +1,25 @@
+package main
+
+import "strings"
+
+// stringList is a flag.Value collecting every occurrence of a repeatable
+// flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// splitKeyValue splits a key=value flag argument.
+func splitKeyValue(s string) (string, string, bool) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"strings"
+)
+
+// generateMapping infers an ES mapping from a file of sample payloads, one
+// JSON object per line, and writes it to out. The result is a starting point
+// for a hand tuned mapping file rather than something to use blindly.
+func generateMapping(samples, out string) error {
+	f, err := os.Open(samples)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	props := make(map[string]interface{})
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line, docs := 0, 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &doc); err != nil {
+			fmt.Printf("Skipping line %d: %v\n", line, err)
+			continue
+		}
+		inferProperties(props, doc)
+		docs++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// The fields the ingester adds itself always have a known type.
+	props["src_location"] = map[string]interface{}{"type": "geo_point"}
+	props["dest_location"] = map[string]interface{}{"type": "geo_point"}
+	props["timestamp"] = map[string]interface{}{"type": "date"}
+	props["ingest_lag_seconds"] = map[string]interface{}{"type": "double"}
+	props["ingest_lag_ms"] = map[string]interface{}{"type": "long"}
+	if includeProvenance {
+		hp := map[string]interface{}{}
+		for _, f := range []string{"channel", "broker", "broker_host", "ident"} {
+			hp[f] = map[string]interface{}{"type": "keyword"}
+		}
+		props[ProvenanceField] = map[string]interface{}{"properties": hp}
+	}
+
+	mapping := map[string]interface{}{
+		"mappings": map[string]interface{}{"properties": props},
+	}
+	buf, err := json.MarshalIndent(mapping, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(out, append(buf, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote mapping for %d fields from %d samples to %s\n", len(props), docs, out)
+	return nil
+}
+
+// inferProperties merges the fields of doc into props, widening types when
+// samples disagree.
+func inferProperties(props map[string]interface{}, doc map[string]interface{}) {
+	for name, v := range doc {
+		// Arrays map to the type of their elements.
+		if arr, ok := v.([]interface{}); ok {
+			for _, e := range arr {
+				inferField(props, name, e)
+			}
+			continue
+		}
+		inferField(props, name, v)
+	}
+}
+
+func inferField(props map[string]interface{}, name string, v interface{}) {
+	if v == nil {
+		return
+	}
+
+	if obj, ok := v.(map[string]interface{}); ok {
+		field, _ := props[name].(map[string]interface{})
+		sub, ok := field["properties"].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			props[name] = map[string]interface{}{"properties": sub}
+		}
+		inferProperties(sub, obj)
+		return
+	}
+
+	typ := inferType(name, v)
+	if field, ok := props[name].(map[string]interface{}); ok {
+		typ = widenType(fmt.Sprint(field["type"]), typ)
+	}
+	props[name] = map[string]interface{}{"type": typ}
+}
+
+// inferType picks an ES field type for a single JSON value.
+func inferType(name string, v interface{}) string {
+	if strings.HasSuffix(name, "_location") {
+		return "geo_point"
+	}
+	switch v := v.(type) {
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return "long"
+		}
+		return "double"
+	case string:
+		if net.ParseIP(v) != nil {
+			return "ip"
+		}
+		if _, ok := parseEventTime(v); ok && !isNumeric(v) {
+			return "date"
+		}
+	}
+	return "keyword"
+}
+
+// widenType returns a type able to hold values of both a and b.
+func widenType(a, b string) string {
+	switch {
+	case a == b:
+		return a
+	case (a == "long" && b == "double") || (a == "double" && b == "long"):
+		return "double"
+	default:
+		return "keyword"
+	}
+}
+
+func isNumeric(s string) bool {
+	var f float64
+	_, err := fmt.Sscan(s, &f)
+	return err == nil
+}
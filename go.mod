@@ -1,8 +1,13 @@
 module github.com/d1str0/hpfeeds-elastic
 
+go 1.16
+
 require (
-	github.com/d1str0/hpfeeds v0.1.3
+	github.com/itchyny/gojq v0.12.7
+	github.com/klauspost/compress v1.15.1
 	github.com/mailru/easyjson v0.0.0-20190403194419-1ea4449da983 // indirect
-	github.com/olivere/elastic v6.2.17+incompatible
+	github.com/mmcloughlin/geohash v0.10.0
 	github.com/olivere/elastic/v7 v7.0.1
+	github.com/oschwald/maxminddb-golang v1.8.0
+	go.uber.org/goleak v1.1.12
 )
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/itchyny/gojq"
+)
+
+// hotSettings are the settings of the document pipeline that a SIGHUP can
+// change while running: the field filters and rewrites, and the enrichment
+// they feed. They're replaced as a whole, and processPayloads takes one
+// snapshot per message, so no document sees half of a reload.
+type hotSettings struct {
+	renames          renamer    // -rename and -rename-file.
+	aliases          appAliases // -app-alias and -app-alias-file.
+	transformRules   appRules   // -transforms-file, or nil.
+	tags             map[string]string
+	tagPrecedence    string
+	keepSet          fieldSet   // -keep-fields, or nil to keep everything.
+	transform        *gojq.Code // -transform, or nil.
+	geohashPrecision uint
+}
+
+// liveSettings holds the current *hotSettings.
+var liveSettings atomic.Value
+
+// currentSettings returns the hot settings in effect.
+func currentSettings() *hotSettings {
+	return liveSettings.Load().(*hotSettings)
+}
+
+// buildHotSettings parses the hot settings from their flags, reading any
+// files they name.
+func buildHotSettings() (*hotSettings, error) {
+	h := &hotSettings{tagPrecedence: tagPrecedence, geohashPrecision: geohashPrecision}
+	if tagPrecedence != "tag" && tagPrecedence != "payload" {
+		return nil, fmt.Errorf("-tag-precedence must be tag or payload, not %q", tagPrecedence)
+	}
+	if geohashPrecision > 12 {
+		return nil, fmt.Errorf("-geohash-precision must be between 0 and 12")
+	}
+	// Not a hot setting as such, but reloaded along with them, and an
+	// interval of 0 would turn log sampling off.
+	if parseLogLimit > 0 && parseLogInterval <= 0 {
+		return nil, fmt.Errorf("-parse-log-interval must be positive")
+	}
+
+	var err error
+	if h.renames, err = parseRenames(renameList, renameFile); err != nil {
+		return nil, fmt.Errorf("-rename: %v", err)
+	}
+	if h.aliases, err = parseAppAliases(appAliasList, appAliasFile); err != nil {
+		return nil, fmt.Errorf("-app-alias: %v", err)
+	}
+	if transformsFile != "" {
+		if h.transformRules, err = loadAppRules(transformsFile); err != nil {
+			return nil, fmt.Errorf("-transforms-file: %v", err)
+		}
+	}
+	if h.tags, err = parseTags(tagArgs); err != nil {
+		return nil, fmt.Errorf("-tag: %v", err)
+	}
+
+	if keepList != "" {
+		h.keepSet = parseFieldSet(keepList)
+		for _, f := range injectedFields {
+			h.keepSet.add(f)
+		}
+		for k := range h.tags {
+			h.keepSet.add(k)
+		}
+	}
+
+	if transformProgram != "" {
+		if h.transform, err = compileTransform(transformProgram); err != nil {
+			return nil, fmt.Errorf("-transform: %v", err)
+		}
+	}
+	return h, nil
+}
+
+// reloadSettings rebuilds the hot settings from their flags, re-reading the
+// files they name, and swaps them in. On error the current ones are kept.
+func reloadSettings() error {
+	h, err := buildHotSettings()
+	if err != nil {
+		return err
+	}
+	liveSettings.Store(h)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+)
+
+// withHotFlags sets the flags buildHotSettings reads for the duration of
+// the test.
+func withHotFlags(t *testing.T, keep, program string, tagList ...string) {
+	t.Helper()
+	oldKeep, oldProgram, oldTags := keepList, transformProgram, tagArgs
+	oldPrecedence, oldPrecision := tagPrecedence, geohashPrecision
+	t.Cleanup(func() {
+		keepList, transformProgram, tagArgs = oldKeep, oldProgram, oldTags
+		tagPrecedence, geohashPrecision = oldPrecedence, oldPrecision
+	})
+	keepList, transformProgram, tagArgs = keep, program, tagList
+	tagPrecedence = "tag"
+}
+
+func TestBuildHotSettings(t *testing.T) {
+	withHotFlags(t, "src_ip", ".x = 1", "env=prod")
+	h, err := buildHotSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.tags["env"] != "prod" {
+		t.Errorf("tags = %v", h.tags)
+	}
+	// Tags and injected fields survive the allowlist.
+	for _, f := range []string{"src_ip", "env", "timestamp"} {
+		if _, ok := h.keepSet[f]; !ok {
+			t.Errorf("keep set is missing %s", f)
+		}
+	}
+	if h.transform == nil {
+		t.Error("transform not compiled")
+	}
+}
+
+func TestBuildHotSettingsInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		tags    []string
+	}{
+		{"transform", ".x = ", nil},
+		{"tag", "", []string{"novalue"}},
+	}
+	for _, tt := range tests {
+		withHotFlags(t, "", tt.program, tt.tags...)
+		if _, err := buildHotSettings(); err == nil {
+			t.Errorf("%s: invalid settings accepted", tt.name)
+		}
+	}
+}
+
+func TestReloadSettingsKeepsCurrentOnError(t *testing.T) {
+	withHotFlags(t, "", "", "env=prod")
+	if err := reloadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	withHotFlags(t, "", ".x = ")
+	if err := reloadSettings(); err == nil {
+		t.Fatal("invalid transform accepted")
+	}
+	if got := currentSettings().tags["env"]; got != "prod" {
+		t.Errorf("settings were replaced, env = %q", got)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// targetIndexes records the indexes written to since the last check, with
+// the app (or channel) of each, so the checker only looks at indexes that
+// are actually in use and can render their mapping.
+var targetIndexes = struct {
+	sync.Mutex
+	names map[string]string
+}{names: map[string]string{}}
+
+// markTargetIndex notes that a document of app was queued for index. app is
+// empty for the -no-geo-index and -late-index, and with -index-template the
+// last app written to the index wins.
+func markTargetIndex(index, app string) {
+	targetIndexes.Lock()
+	targetIndexes.names[index] = app
+	targetIndexes.Unlock()
+}
+
+// takeTargetIndexes returns the indexes written since the last call, with
+// their apps, and starts a new set.
+func takeTargetIndexes() map[string]string {
+	targetIndexes.Lock()
+	defer targetIndexes.Unlock()
+
+	names := targetIndexes.names
+	targetIndexes.names = map[string]string{}
+	return names
+}
+
+// checkIndexes periodically makes sure every index written to recently
+// still exists, recreating missing ones from the mapping file. This heals
+// an index deleted by hand or lost in an incident without having to rerun
+// -init. Each wait is jittered by up to 20% either way so a fleet of
+// ingesters doesn't check in lockstep. It returns on shutdown.
+func checkIndexes(client *elastic.Client, interval time.Duration, mappingFile string) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		jitter := time.Duration((rng.Float64()*0.4 - 0.2) * float64(interval))
+		if sleepOrShutdown(interval + jitter) {
+			return
+		}
+
+		names := takeTargetIndexes()
+		if len(names) == 0 {
+			continue
+		}
+
+		ctx := context.Background()
+		for index, app := range names {
+			exists, err := client.IndexExists(index).Do(ctx)
+			if err != nil {
+				log.Printf("Index check: %s: %v\n", index, err)
+				continue
+			}
+			if exists {
+				continue
+			}
+
+			log.Printf("Index check: %s is missing, recreating\n", index)
+			buf, err := renderMappingFile(mappingFile, app, index)
+			if err != nil {
+				log.Printf("Index check: error reading mapping file: %v\n", err)
+				continue
+			}
+			if _, err := createTargetIndex(ctx, client, index, buf); err != nil {
+				// Another writer may have recreated it in the meantime.
+				log.Printf("Index check: error creating %s: %v\n", index, err)
+				continue
+			}
+			indexesRecreated.Add(1)
+		}
+	}
+}
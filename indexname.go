@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// TemplateName is the name of the ES index template installed by -init when
+// -index-template is in use.
+const TemplateName = "hpfeeds-elastic"
+
+// MissingField is substituted for template placeholders whose field is
+// absent from the document.
+const MissingField = "unknown"
+
+var (
+	placeholder  = regexp.MustCompile(`\{([^{}]+)\}`)
+	illegalChars = regexp.MustCompile(`[\\/*?"<>| ,#:]+`)
+)
+
+// indexName returns the index a document belongs in. Without -index-template
+// this is -index-prefix followed by the app name. With a template, each
+// {field} placeholder is replaced by that field's value in doc; dotted paths
+// reach into nested objects.
+func indexName(app string, doc map[string]interface{}) string {
+	if indexTemplate == "" {
+		return currentIndex(indexPrefix + app)
+	}
+
+	name := placeholder.ReplaceAllStringFunc(indexTemplate, func(m string) string {
+		field := m[1 : len(m)-1]
+		v, ok := lookupField(doc, field)
+		if !ok || v == nil {
+			return MissingField
+		}
+		s := sanitizeIndexPart(fmt.Sprint(v))
+		if s == "" {
+			return MissingField
+		}
+		return s
+	})
+	return currentIndex(strings.ToLower(name))
+}
+
+// noGeoIndexName returns the -no-geo-index index for documents written now.
+func noGeoIndexName() string {
+	return currentIndex(noGeoIndex)
+}
+
+// lateIndexName returns the -late-index index for documents written now.
+func lateIndexName() string {
+	return currentIndex(lateIndex)
+}
+
+// sharedIndexNames returns the indexes written now that hold documents of
+// every app: the -no-geo-index and -late-index, if set.
+func sharedIndexNames() []string {
+	var names []string
+	if noGeoIndex != "" {
+		names = append(names, noGeoIndexName())
+	}
+	if lateIndex != "" {
+		names = append(names, lateIndexName())
+	}
+	return names
+}
+
+// currentIndex returns the name to write to now for the index named base:
+// base itself, base with the -index-date-pattern suffix, or with
+// -index-date-math a date math name such as
+// <mhn-community-data-cowrie-{now/d}>, which ES resolves to the current
+// period's index on every request. The client URL-encodes such names where
+// they appear in a path, as ES requires; in bulk bodies they're sent as is.
+func currentIndex(base string) string {
+	if indexDateMath != "" {
+		return "<" + base + "-" + indexDateMath + ">"
+	}
+	return base + dateSuffix(time.Now())
+}
+
+// validDateMath reports whether expr looks like an ES date math expression
+// such as {now/d} or {now/M{yyyy.MM}}: a single {now...} group with
+// balanced braces.
+func validDateMath(expr string) bool {
+	if !strings.HasPrefix(expr, "{now") || !strings.HasSuffix(expr, "}") {
+		return false
+	}
+	depth := 0
+	for i, c := range expr {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 && i != len(expr)-1 {
+				return false
+			}
+		}
+		if depth < 0 {
+			return false
+		}
+	}
+	return depth == 0
+}
+
+// dateSuffix returns the rollover suffix for an index written at t, or an
+// empty string if -index-date-pattern isn't set. The granularity of the
+// pattern decides how often indexes roll over: "2006.01.02" gives daily
+// indexes, "2006.01" monthly and "2006" yearly.
+func dateSuffix(t time.Time) string {
+	if indexDatePattern == "" {
+		return ""
+	}
+	return "-" + t.UTC().Format(indexDatePattern)
+}
+
+// sanitizeIndexPart lowercases s and strips characters ES doesn't allow in
+// index names.
+func sanitizeIndexPart(s string) string {
+	s = strings.ToLower(s)
+	s = illegalChars.ReplaceAllString(s, "")
+	return strings.TrimLeft(s, "-_+.")
+}
+
+// indexKeys returns the names the per-index commands (-init, -update-mapping,
+// etc.) iterate over: the hpfeeds channels with -index-by channel, otherwise
+// the known apps.
+func indexKeys() []string {
+	if indexBy == "channel" {
+		var keys []string
+		for _, c := range channels {
+			keys = append(keys, sanitizeIndexPart(c))
+		}
+		return keys
+	}
+	return Apps
+}
+
+// appIndexPattern returns the name of app's index, or a pattern matching all
+// of its dated indexes when -index-date-pattern or -index-date-math is set,
+// or all of its backing indexes with -write-alias.
+func appIndexPattern(app string) string {
+	index := fmt.Sprintf("%s%s", indexPrefix, app)
+	if indexDatePattern != "" || indexDateMath != "" || writeAlias {
+		index += "-*"
+	}
+	return index
+}
+
+// deletePatterns returns the index names and patterns -init-override
+// deletes: those in -delete-indexes, or else every app's indexes.
+func deletePatterns() []string {
+	var patterns []string
+	for _, pattern := range strings.Split(deleteList, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) > 0 {
+		return patterns
+	}
+	for _, app := range indexKeys() {
+		patterns = append(patterns, appIndexPattern(app))
+	}
+	return patterns
+}
+
+// templatePattern returns the index pattern matching every name the
+// -index-template can produce: the literal prefix up to the first
+// placeholder, followed by a wildcard. Without a template it matches every
+// dated app index.
+func templatePattern() string {
+	if indexTemplate == "" {
+		return indexPrefix + "*"
+	}
+	if i := strings.Index(indexTemplate, "{"); i >= 0 {
+		return indexTemplate[:i] + "*"
+	}
+	return indexTemplate + "*"
+}
+
+// putIndexTemplate installs an ES index template carrying the mapping file,
+// so indexes created on the fly from -index-template get the right mapping.
+// Dynamic index names can't be enumerated up front the way per-app indexes
+// can, which is why createIndex isn't used in this case.
+func putIndexTemplate(client *elastic.Client, mappingFile string) {
+	buf, err := readMappingFile(mappingFile)
+	if err != nil {
+		log.Fatalf("Error reading mapping file: %v", err)
+	}
+
+	body, err := templateBody(buf)
+	if err != nil {
+		log.Fatalf("Error parsing mapping file: %v", err)
+	}
+
+	res, err := client.IndexPutTemplate(TemplateName).BodyJson(body).Do(context.Background())
+	if err != nil {
+		log.Fatalf("Error putting index template: %v", err)
+	}
+	if !res.Acknowledged {
+		log.Print("Put index template: Not acknowledged")
+	}
+	log.Printf("Installed index template %s for %s\n", TemplateName, templatePattern())
+}
+
+// templateBody returns the body of the index template putIndexTemplate
+// installs, given the mapping file contents.
+func templateBody(mapping []byte) (map[string]interface{}, error) {
+	var body map[string]interface{}
+	if err := json.Unmarshal(mapping, &body); err != nil {
+		return nil, err
+	}
+	body["index_patterns"] = []string{templatePattern()}
+	return body, nil
+}
+
+// usesIndexTemplate reports whether -init installs the index template:
+// whenever indexes are created after -init, by ES or by rollover.
+func usesIndexTemplate() bool {
+	return indexTemplate != "" || indexDatePattern != "" || indexDateMath != "" || writeAlias
+}
@@ -0,0 +1,331 @@
+package hpfeeds
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// errNotConnected is returned when writing without a connection.
+var errNotConnected = errors.New("not connected")
+
+// Client stores internal state for on connection. On disconnection,
+// the Disconnected channel (buffered) will be written. Set LocalAddr to set a
+// local IP address and port which the connection should bind to on connect.
+type Client struct {
+	LocalAddr net.TCPAddr
+
+	Host  string
+	Port  int
+	Ident string
+	Auth  string
+
+	Disconnected chan error
+
+	// Unsubscribed, if set, receives publishes on channels that weren't
+	// subscribed to, which are otherwise dropped. Brokers aren't supposed
+	// to send those, so it's set to find out when one does.
+	Unsubscribed chan Message
+
+	Log bool
+
+	mu      sync.Mutex              // Guards the fields below.
+	conn    *net.TCPConn            // The current connection, nil when closed.
+	done    chan struct{}           // Closed when conn is.
+	authed  bool                    // Whether conn has been authenticated.
+	channel map[string]chan Message // Subscriptions by hpfeeds channel.
+
+	wmu      sync.Mutex // Keeps messages whole when written concurrently.
+	authSent chan bool
+}
+
+// NewClient returns a new Client object and initializes necessary channels.
+func NewClient(host string, port int, ident string, auth string) *Client {
+	return &Client{
+		Host:  host,
+		Port:  port,
+		Ident: ident,
+		Auth:  auth,
+
+		authSent:     make(chan bool),
+		Disconnected: make(chan error, 1),
+
+		channel: make(map[string]chan Message),
+	}
+}
+
+// Connect establishes a new hpfeeds connection and will block until the
+// connection is successfully established or the connection attempt failed.
+func (c *Client) Connect() error {
+	c.clearDisconnected()
+
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", c.Host, c.Port))
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTCP("tcp", &c.LocalAddr, addr)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.conn, c.done = conn, done
+	c.mu.Unlock()
+	go c.recvLoop(conn, done)
+	select {
+	case <-c.authSent:
+	case <-done:
+	}
+
+	select {
+	case err = <-c.Disconnected:
+		return err
+	default:
+	}
+	return nil
+}
+
+func (c *Client) clearDisconnected() {
+	select {
+	case <-c.Disconnected:
+	default:
+	}
+}
+
+// Returns given error on the Disconnected chan.
+func (c *Client) setDisconnected(err error) {
+	c.clearDisconnected()
+	c.Disconnected <- err
+}
+
+// Close closes the hpfeeds connection and signals the Disconnected channel.
+// It may be called from any goroutine, and does nothing if the connection
+// is already closed.
+func (c *Client) Close() {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		c.close(conn, nil)
+	}
+}
+
+// close closes conn if it's still the current connection.
+func (c *Client) close(conn *net.TCPConn, err error) {
+	c.mu.Lock()
+	if c.conn != conn {
+		c.mu.Unlock()
+		return
+	}
+	c.conn, c.authed = nil, false
+	done := c.done
+	c.mu.Unlock()
+
+	conn.Close()
+	c.setDisconnected(err)
+	close(done)
+}
+
+// current returns the current connection, or nil.
+func (c *Client) current() *net.TCPConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *Client) recvLoop(conn *net.TCPConn, done chan struct{}) {
+	// Prepare a buffer for reading from the wire.
+	var buf []byte
+	readbuf := make([]byte, 1024)
+
+	for {
+		n, err := conn.Read(readbuf)
+		if err != nil {
+			c.log("Read(): %s\n", err)
+			c.close(conn, err)
+			return
+		}
+
+		buf = append(buf, readbuf[:n]...)
+
+		for len(buf) > 5 {
+			hdr := messageHeader{}
+			hdr.Length = binary.BigEndian.Uint32(buf[0:4]) // Get the length of the message.
+			hdr.Opcode = uint8(buf[4])
+			// Check to see if buf holds the full message or if we need to get more data off the wire first.
+			if len(buf) < int(hdr.Length) {
+				break
+			}
+			if hdr.Length < 5 {
+				c.close(conn, fmt.Errorf("invalid message length %d", hdr.Length))
+				return
+			}
+			data := buf[5:int(hdr.Length)]
+			c.parse(hdr.Opcode, data, done)
+			buf = buf[int(hdr.Length):]
+		}
+	}
+}
+
+func (c *Client) parse(opcode uint8, data []byte, done chan struct{}) {
+	switch opcode {
+	case OpInfo:
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			c.log("Received invalid OpInfo\n")
+			return
+		}
+		c.log("Received OpInfo from %s\n", data[1:1+int(data[0])])
+		c.sendAuth(data[(1 + int(data[0])):])
+		var names []string
+		c.mu.Lock()
+		if c.conn != nil && c.done == done {
+			c.authed = true
+			for name := range c.channel {
+				names = append(names, name)
+			}
+		}
+		c.mu.Unlock()
+		for _, name := range names {
+			c.sendSub(name)
+		}
+		select {
+		case c.authSent <- true:
+		case <-done:
+		}
+	case OpErr:
+		c.log("Received error from server: %s\n", string(data))
+	case OpPublish:
+		name, rest, ok := readField(data)
+		if !ok {
+			c.log("Received invalid publish\n")
+			return
+		}
+		channel, payload, ok := readField(rest)
+		if !ok {
+			c.log("Received invalid publish\n")
+			return
+		}
+		c.handlePub(string(name), string(channel), payload, done)
+	default:
+		c.log("Received message with unknown type %d\n", opcode)
+	}
+}
+
+// readField splits a length prefixed field off the front of data.
+func readField(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, nil, false
+	}
+	n := int(data[0])
+	return data[1 : 1+n], data[1+n:], true
+}
+
+func (c *Client) handlePub(name string, channelName string, payload []byte, done chan struct{}) {
+	c.mu.Lock()
+	channel, ok := c.channel[channelName]
+	c.mu.Unlock()
+	if !ok {
+		if c.Unsubscribed == nil {
+			c.log("Received message on unsubscribed channel %s\n", channelName)
+			return
+		}
+		channel = c.Unsubscribed
+	}
+	// Give up on delivering once the connection is closed, so the
+	// receive loop can't outlive it waiting for a reader.
+	select {
+	case channel <- Message{name, channelName, payload}:
+	case <-done:
+	}
+}
+
+func (c *Client) sendRawMsg(opcode uint8, data []byte) error {
+	conn := c.current()
+	if conn == nil {
+		return errNotConnected
+	}
+	buf := make([]byte, 5)
+	binary.BigEndian.PutUint32(buf, uint32(5+len(data)))
+	buf[4] = byte(opcode)
+	buf = append(buf, data...)
+
+	c.wmu.Lock()
+	_, err := conn.Write(buf)
+	c.wmu.Unlock()
+	if err != nil {
+		c.log("Write(): %s\n", err)
+		c.close(conn, err)
+	}
+	return err
+}
+
+func (c *Client) sendAuth(nonce []byte) {
+	buf := new(bytes.Buffer)
+	mac := sha1.New()
+	mac.Write(nonce)
+	mac.Write([]byte(c.Auth))
+	writeField(buf, []byte(c.Ident))
+	buf.Write(mac.Sum(nil))
+	c.sendRawMsg(OpAuth, buf.Bytes())
+}
+
+func (c *Client) sendSub(channelName string) {
+	buf := new(bytes.Buffer)
+	writeField(buf, []byte(c.Ident))
+	buf.Write([]byte(channelName))
+	c.sendRawMsg(OpSubscribe, buf.Bytes())
+}
+
+func (c *Client) sendPub(channelName string, payload []byte) error {
+	buf := new(bytes.Buffer)
+	writeField(buf, []byte(c.Ident))
+	writeField(buf, []byte(channelName))
+	buf.Write(payload)
+	return c.sendRawMsg(OpPublish, buf.Bytes())
+}
+
+// Subscribe sends a subscribe message to the hpfeeds server. All incoming
+// messages on the given hpfeeds channel will now be written to the given Go
+// channel. Subscriptions are kept across connections: a channel subscribed
+// before Connect, or before a reconnect, is subscribed to as soon as the
+// client has authenticated and before any message is read.
+func (c *Client) Subscribe(channelName string, channel chan Message) {
+	c.mu.Lock()
+	c.channel[channelName] = channel
+	authed := c.authed
+	c.mu.Unlock()
+	if authed {
+		c.sendSub(channelName)
+	}
+}
+
+// Publish starts a new goroutine which reads from the given Go channel
+// and for each item sends a publish message to the given hpfeeds channel.
+// If the Go channel is externally closed, or the connection is, the
+// goroutine will exit.
+func (c *Client) Publish(channelName string, channel chan []byte) {
+	go func() {
+		for payload := range channel {
+			if c.sendPub(channelName, payload) != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (c *Client) log(format string, v ...interface{}) {
+	if c.Log {
+		log.Printf(format, v...)
+	}
+}
+
+func writeField(buf *bytes.Buffer, data []byte) {
+	buf.WriteByte(byte(len(data)))
+	buf.Write(data)
+}
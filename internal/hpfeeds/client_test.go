@@ -0,0 +1,206 @@
+package hpfeeds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+// testBroker accepts connections, greets each with OpInfo and hands it to
+// the test.
+func testBroker(t *testing.T) (*Client, chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	conns := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			buf := new(bytes.Buffer)
+			writeField(buf, []byte("test"))
+			buf.WriteString("0123")
+			writeMsg(conn, OpInfo, buf.Bytes())
+			conns <- conn
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return NewClient(addr.IP.String(), addr.Port, "ident", "secret"), conns
+}
+
+func writeMsg(conn net.Conn, opcode uint8, data []byte) {
+	hdr := make([]byte, 5)
+	binary.BigEndian.PutUint32(hdr, uint32(len(hdr)+len(data)))
+	hdr[4] = opcode
+	conn.Write(append(hdr, data...))
+}
+
+func publish(conn net.Conn, name, channel, payload string) {
+	buf := new(bytes.Buffer)
+	writeField(buf, []byte(name))
+	writeField(buf, []byte(channel))
+	buf.WriteString(payload)
+	writeMsg(conn, OpPublish, buf.Bytes())
+}
+
+func waitDisconnected(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case <-c.Disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't disconnect")
+	}
+}
+
+func TestCloseWhileReading(t *testing.T) {
+	c, conns := testBroker(t)
+	for i := 0; i < 20; i++ {
+		if err := c.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		<-conns
+		// The receive loop closes the connection on the read error
+		// this causes, racing the second Close.
+		go c.Close()
+		c.Close()
+		waitDisconnected(t, c)
+	}
+}
+
+func TestCloseWhileDelivering(t *testing.T) {
+	c, conns := testBroker(t)
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn := <-conns
+	c.Subscribe("chan", make(chan Message)) // Never read.
+	publish(conn, "sensor", "chan", "{}")
+	time.Sleep(50 * time.Millisecond)
+
+	// The receive loop gives up on the message rather than outliving
+	// the connection.
+	c.Close()
+	waitDisconnected(t, c)
+}
+
+func TestCloseByBroker(t *testing.T) {
+	c, conns := testBroker(t)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn := <-conns
+	conn.Close()
+	waitDisconnected(t, c)
+	c.Close() // Already closed, does nothing.
+	select {
+	case err := <-c.Disconnected:
+		t.Errorf("second disconnect signalled: %v", err)
+	default:
+	}
+}
+
+func TestPublishAfterClose(t *testing.T) {
+	c, conns := testBroker(t)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	<-conns
+	c.Close()
+	waitDisconnected(t, c)
+	if err := c.sendPub("chan", []byte("{}")); err != errNotConnected {
+		t.Errorf("sendPub after Close = %v, want %v", err, errNotConnected)
+	}
+}
+
+// readMsg reads one message off conn.
+func readMsg(t *testing.T, conn net.Conn) (uint8, []byte) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(hdr)-5)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	return hdr[4], data
+}
+
+func TestSubscribeBeforeConnect(t *testing.T) {
+	c, conns := testBroker(t)
+	c.Subscribe("chan", make(chan Message, 1))
+	for i := 0; i < 2; i++ {
+		if err := c.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		conn := <-conns
+		if op, _ := readMsg(t, conn); op != OpAuth {
+			t.Fatalf("first message has opcode %d, want OpAuth", op)
+		}
+		op, data := readMsg(t, conn)
+		_, channel, _ := readField(data)
+		if op != OpSubscribe || string(channel) != "chan" {
+			t.Fatalf("second message = %d %q, want a subscription to chan", op, channel)
+		}
+		c.Close()
+		waitDisconnected(t, c)
+	}
+}
+
+func TestSubscribeWhileDelivering(t *testing.T) {
+	c, conns := testBroker(t)
+	msgs := make(chan Message)
+	c.Subscribe("chan", msgs)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn := <-conns
+	defer c.Close()
+	go func() {
+		for i := 0; i < 100; i++ {
+			publish(conn, "sensor", "chan", "{}")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		c.Subscribe(fmt.Sprintf("other%d", i), make(chan Message))
+		<-msgs
+	}
+}
+
+func TestUnsubscribed(t *testing.T) {
+	c, conns := testBroker(t)
+	msgs := make(chan Message, 1)
+	c.Subscribe("chan", msgs)
+	c.Unsubscribed = make(chan Message, 1)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn := <-conns
+	defer c.Close()
+
+	publish(conn, "sensor", "other", "{}")
+	publish(conn, "sensor", "chan", "{}")
+	want := Message{"sensor", "chan", []byte("{}")}
+	if m := <-msgs; !reflect.DeepEqual(m, want) {
+		t.Errorf("subscribed message = %+v, want %+v", m, want)
+	}
+	want.Channel = "other"
+	if m := <-c.Unsubscribed; !reflect.DeepEqual(m, want) {
+		t.Errorf("unsubscribed message = %+v, want %+v", m, want)
+	}
+}
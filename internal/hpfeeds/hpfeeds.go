@@ -0,0 +1,34 @@
+// Package hpfeeds is a client for the hpfeeds pub/sub protocol of the
+// Honeynet Project, see https://github.com/rep/hpfeeds.
+//
+// It started as a copy of the client of github.com/d1str0/hpfeeds v0.1.3
+// (BSD licensed), changed so a connection can be closed from any goroutine
+// while the client is reading from it: Close and the receive loop both close
+// it, and whichever comes second does nothing.
+package hpfeeds
+
+// These are the designated opcodes for use on the wire.
+// No iota because we want to make it clear it follows the defined hpfeeds spec.
+const (
+	OpErr       = 0
+	OpInfo      = 1
+	OpAuth      = 2
+	OpPublish   = 3
+	OpSubscribe = 4
+
+	SizeOfNonce = 4 // 4 Bytes
+)
+
+// Message describes the format of hpfeeds messages, where Name represents the
+// hpfeeds identifier of the sender, Channel the hpfeeds channel it was
+// published on and Payload contains the actual data.
+type Message struct {
+	Name    string
+	Channel string
+	Payload []byte
+}
+
+type messageHeader struct {
+	Length uint32
+	Opcode uint8
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// MaxLineSize is the longest line -listen accepts. Longer lines end the
+// connection.
+const MaxLineSize = 1 << 20
+
+// serveListener accepts connections on ln and feeds every line of newline
+// delimited JSON they send into out, as a message on -listen-channel, until
+// shutdown. With -listen-app, objects without an app get that one.
+func serveListener(ln net.Listener, out chan message) {
+	var (
+		mu     sync.Mutex
+		open   = make(map[net.Conn]bool)
+		closed bool
+		conns  sync.WaitGroup
+	)
+
+	// Closing the listener and connections unblocks Accept and the reads.
+	go func() {
+		<-shuttingDown
+		mu.Lock()
+		closed = true
+		ln.Close()
+		for c := range open {
+			c.Close()
+		}
+		mu.Unlock()
+	}()
+
+	log.Printf("Listening for JSON lines on %s\n", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			mu.Lock()
+			done := closed
+			mu.Unlock()
+			if done {
+				break
+			}
+			log.Printf("Error accepting connection: %v\n", err)
+			if sleepOrShutdown(time.Second) {
+				break
+			}
+			continue
+		}
+
+		mu.Lock()
+		if closed {
+			mu.Unlock()
+			conn.Close()
+			break
+		}
+		open[conn] = true
+		mu.Unlock()
+
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			readLines(conn, out)
+			mu.Lock()
+			delete(open, conn)
+			mu.Unlock()
+			conn.Close()
+		}()
+	}
+	conns.Wait()
+}
+
+// readLines feeds the lines read from conn into out until it's closed.
+func readLines(conn net.Conn, out chan message) {
+	remote := conn.RemoteAddr().String()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), MaxLineSize)
+	n := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		messagesReceived.Add(1)
+
+		mes := message{Channel: listenChannel, Broker: listenAddr, Received: time.Now()}
+		mes.Payload = withApp(line, listenApp)
+		select {
+		case out <- mes:
+			n++
+		case <-shuttingDown:
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading from %s: %v\n", remote, err)
+	}
+	log.Printf("Received %d lines from %s\n", n, remote)
+}
+
+// withApp returns a copy of line, adding app as its app field if it's a
+// JSON object without one. Anything else, including lines that aren't JSON,
+// is passed on as is for the usual parse error handling.
+func withApp(line []byte, app string) []byte {
+	if app != "" {
+		var doc map[string]interface{}
+		if unmarshalDoc(line, &doc) == nil && doc != nil {
+			if _, ok := doc["app"]; !ok {
+				doc["app"] = app
+				if buf, err := json.Marshal(doc); err == nil {
+					return buf
+				}
+			}
+		}
+	}
+	// The scanner reuses its buffer for the next line.
+	return append([]byte(nil), line...)
+}
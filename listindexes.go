@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// listIndexes prints the indexes matching the configured prefix with their
+// doc counts and sizes, grouped by app (or channel with -index-by channel).
+// Indexes that don't belong to a known app, e.g. ones made from
+// -index-template, are listed last.
+func listIndexes(client *elastic.Client) error {
+	rows, err := catIndexes(client, templatePattern())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "APP\tINDEX\tDOCS\tSIZE")
+
+	seen := make(map[string]bool)
+	for _, key := range indexKeys() {
+		name := indexPrefix + key
+		for _, row := range rows {
+			if row.Index != name && !strings.HasPrefix(row.Index, name+"-") {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", key, row.Index, row.DocsCount, row.StoreSize)
+			seen[row.Index] = true
+		}
+	}
+	for _, row := range rows {
+		if !seen[row.Index] {
+			fmt.Fprintf(w, "-\t%s\t%d\t%s\n", row.Index, row.DocsCount, row.StoreSize)
+		}
+	}
+	return w.Flush()
+}
+
+// catIndexes returns the name, doc count and size of the indexes matching
+// pattern, sorted by name.
+func catIndexes(client *elastic.Client, pattern string) (elastic.CatIndicesResponse, error) {
+	rows, err := client.CatIndices().
+		Index(pattern).
+		Columns("index", "docs.count", "store.size").
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Index < rows[j].Index })
+	return rows, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sampledLogger logs at most limit messages per interval. Anything past the
+// limit is dropped and counted, and a single summary line with the number of
+// suppressed messages is printed when the interval ends. A limit of 0 or less
+// disables sampling and logs every message.
+//
+// With a dedup window, repeats of a message are collapsed as well: the
+// first is logged, and repeats within the window only counted and summed up
+// in one "N occurrences of" line at its end. Messages are compared by their
+// first line, so errors logged along with the payload that caused them
+// still collapse.
+type sampledLogger struct {
+	mu         sync.Mutex
+	limit      int
+	interval   time.Duration
+	start      time.Time
+	logged     int
+	suppressed int
+
+	dedup   time.Duration
+	repeats map[string]int // First line -> repeats in its window.
+}
+
+func newSampledLogger(limit int, interval, dedup time.Duration) *sampledLogger {
+	return &sampledLogger{limit: limit, interval: interval, dedup: dedup, repeats: make(map[string]int)}
+}
+
+// Printf logs the message unless it repeats one logged within the dedup
+// window, and if the current interval still has room for it.
+func (l *sampledLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.dedup > 0 {
+		msg := fmt.Sprintf(format, v...)
+		key := strings.TrimSpace(strings.SplitN(msg, "\n", 2)[0])
+		if _, ok := l.repeats[key]; ok {
+			l.repeats[key]++
+			return
+		}
+		l.repeats[key] = 0
+		window := l.dedup
+		time.AfterFunc(window, func() { l.endRepeats(key, window) })
+	}
+
+	if l.limit <= 0 {
+		log.Printf(format, v...)
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(l.start) >= l.interval {
+		if l.suppressed > 0 {
+			log.Printf("Suppressed %d similar log messages in the last %s\n", l.suppressed, l.interval)
+		}
+		l.start = now
+		l.logged = 0
+		l.suppressed = 0
+	}
+
+	if l.logged >= l.limit {
+		// The first suppressed message arms the summary, so it comes out
+		// even if nothing is logged after the interval.
+		if l.suppressed == 0 {
+			start := l.start
+			time.AfterFunc(l.interval-now.Sub(start), func() { l.endInterval(start) })
+		}
+		l.suppressed++
+		return
+	}
+	l.logged++
+	log.Printf(format, v...)
+}
+
+// endInterval ends the interval begun at start, unless a later message or
+// SetLimit already did, summing up what was suppressed.
+func (l *sampledLogger) endInterval(start time.Time) {
+	l.mu.Lock()
+	if !l.start.Equal(start) {
+		l.mu.Unlock()
+		return
+	}
+	n, interval := l.suppressed, l.interval
+	l.start = time.Time{}
+	l.logged = 0
+	l.suppressed = 0
+	l.mu.Unlock()
+
+	if n > 0 {
+		log.Printf("Suppressed %d similar log messages in the last %s\n", n, interval)
+	}
+}
+
+// endRepeats ends the dedup window of key, summing up its repeats.
+func (l *sampledLogger) endRepeats(key string, window time.Duration) {
+	l.mu.Lock()
+	n := l.repeats[key]
+	delete(l.repeats, key)
+	l.mu.Unlock()
+
+	if n > 0 {
+		log.Printf("%d occurrences of %q in the last %s\n", n, key, window)
+	}
+}
+
+// SetDedup changes the dedup window. Windows already started run their
+// course.
+func (l *sampledLogger) SetDedup(window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.dedup = window
+}
+
+// SetLimit changes the sampling settings, ending the current interval.
+func (l *sampledLogger) SetLimit(limit int, interval time.Duration) {
+	l.mu.Lock()
+	n, old := l.suppressed, l.interval
+	l.limit = limit
+	l.interval = interval
+	l.start = time.Time{}
+	l.logged = 0
+	l.suppressed = 0
+	l.mu.Unlock()
+
+	if n > 0 {
+		log.Printf("Suppressed %d similar log messages in the last %s\n", n, old)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe to log to from timers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog sends the standard logger's output to the returned buffer for
+// the rest of the test.
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+	var out syncBuffer
+	flags := log.Flags()
+	log.SetOutput(&out)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	return &out
+}
+
+func TestSampledLoggerSummaryAtIntervalEnd(t *testing.T) {
+	out := captureLog(t)
+	l := newSampledLogger(2, 50*time.Millisecond, 0)
+	for i := 0; i < 5; i++ {
+		l.Printf("error %d\n", i)
+	}
+	if got := strings.Count(out.String(), "error"); got != 2 {
+		t.Fatalf("logged %d messages, want 2:\n%s", got, out)
+	}
+
+	// Nothing else is logged, the summary still comes out.
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "Suppressed 3 similar log messages") {
+		if time.Now().After(deadline) {
+			t.Fatalf("no summary at the end of the interval:\n%s", out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The next interval starts afresh, and isn't summed up twice.
+	l.Printf("error again\n")
+	time.Sleep(100 * time.Millisecond)
+	if got := strings.Count(out.String(), "Suppressed"); got != 1 {
+		t.Errorf("%d summaries, want 1:\n%s", got, out)
+	}
+	if !strings.Contains(out.String(), "error again") {
+		t.Errorf("message in the next interval not logged:\n%s", out)
+	}
+}
+
+func TestSampledLoggerSetLimitSummarizes(t *testing.T) {
+	out := captureLog(t)
+	l := newSampledLogger(1, time.Hour, 0)
+	l.Printf("first\n")
+	l.Printf("second\n")
+	l.SetLimit(1, time.Minute)
+	if !strings.Contains(out.String(), "Suppressed 1 similar log messages in the last 1h0m0s") {
+		t.Errorf("SetLimit didn't sum up the interval it ended:\n%s", out)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// defaultMapping is map.json as of the build, used when no mapping file is
+// given or the one given doesn't exist.
+//
+//go:embed map.json
+var defaultMapping []byte
+
+// readMappingFile reads the mapping file, or returns the built-in default
+// mapping when path is empty or the file doesn't exist. runPreflight warns
+// about the latter. It's rendered for no app in particular; see
+// renderMappingFile.
+func readMappingFile(path string) ([]byte, error) {
+	return renderMappingFile(path, "", "")
+}
+
+// mappingData is what the mapping file can refer to as a Go template, such
+// as "number_of_shards": {{.Shards}} or "_meta": {"app": "{{.App}}"}.
+type mappingData struct {
+	App      string // Empty for the index template and other shared indexes.
+	Index    string // The index or alias created; empty for the index template.
+	Shards   int
+	Replicas int
+}
+
+// renderMappingFile reads the mapping file as readMappingFile does, and
+// renders it as a Go template for the index of app. With
+// -mapping-allow-comments, comments are removed first. A result that isn't
+// a valid JSON object is an error, so an invalid body is never sent to ES.
+func renderMappingFile(path, app, index string) ([]byte, error) {
+	buf, name := defaultMapping, "built-in mapping"
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			buf, name = b, path
+			if mappingComments {
+				buf = stripJSONComments(buf)
+			}
+		}
+	}
+
+	if bytes.Contains(buf, []byte("{{")) {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(buf))
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		data := mappingData{App: app, Index: index, Shards: indexShards, Replicas: indexReplicas}
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		buf = out.Bytes()
+		if app != "" {
+			name += " for " + app
+		}
+	}
+
+	if err := checkMappingJSON(buf); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return buf, nil
+}
+
+// stripJSONComments blanks out // and /* */ comments outside of strings.
+// Comments are replaced by spaces, keeping line breaks, so positions in
+// parse errors still match the file.
+func stripJSONComments(buf []byte) []byte {
+	out := make([]byte, len(buf))
+	copy(out, buf)
+
+	inString, escaped := false, false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			out[i], out[i+1] = ' ', ' '
+			for i += 2; i < len(out); i++ {
+				if out[i] == '*' && i+1 < len(out) && out[i+1] == '/' {
+					out[i], out[i+1] = ' ', ' '
+					i++
+					break
+				}
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+		}
+	}
+	return out
+}
+
+// checkMappingJSON makes sure buf holds a JSON object, describing where it
+// goes wrong if not.
+func checkMappingJSON(buf []byte) error {
+	var v map[string]interface{}
+	err := json.Unmarshal(buf, &v)
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *json.SyntaxError:
+		line, col := lineColumn(buf, err.Offset)
+		return fmt.Errorf("invalid JSON at offset %d (line %d, column %d): %v", err.Offset, line, col, err)
+	case *json.UnmarshalTypeError:
+		return fmt.Errorf("invalid mapping: want a JSON object, got %s", err.Value)
+	default:
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+}
+
+// lineColumn converts a byte offset in buf to a 1-based line and column.
+func lineColumn(buf []byte, offset int64) (int, int) {
+	if offset > int64(len(buf)) {
+		offset = int64(len(buf))
+	}
+	line, col := 1, 1
+	for _, c := range buf[:offset] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// ensureMappedIndex creates index with the mapping file if it doesn't
+// exist yet.
+func ensureMappedIndex(client *elastic.Client, index, mappingFile string) {
+	ctx := context.Background()
+	exists, err := client.IndexExists(index).Do(ctx)
+	if err != nil {
+		log.Printf("Error checking index %s: %v\n", index, err)
+		return
+	}
+	if exists {
+		return
+	}
+	buf, err := renderMappingFile(mappingFile, "", index)
+	if err != nil {
+		log.Printf("Error reading mapping file: %v\n", err)
+		return
+	}
+	if _, err := createTargetIndex(ctx, client, index, buf); err != nil {
+		log.Printf("Error creating index %s: %v\n", index, err)
+		return
+	}
+	log.Printf("Created index %s\n", index)
+}
+
+// indexExists reports whether err is ES refusing to create an index that
+// already exists.
+func indexExists(err error) bool {
+	e, ok := err.(*elastic.Error)
+	return ok && e.Details != nil && e.Details.Type == "resource_already_exists_exception"
+}
+
+// readMappingProperties reads the mapping file and returns its
+// mappings.properties object.
+func readMappingProperties(mappingFile string) (map[string]interface{}, error) {
+	buf, err := readMappingFile(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping struct {
+		Mappings struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal(buf, &mapping); err != nil {
+		return nil, err
+	}
+	return mapping.Mappings.Properties, nil
+}
+
+// updateMappings adds any fields from the mapping file that are missing from
+// the existing app indexes, including every dated or backing index of an
+// app. ES only allows adding new fields to a mapping, so fields whose type
+// differs from the live mapping are reported as conflicts and left alone.
+// Apps without any index are skipped.
+func updateMappings(client *elastic.Client, mappingFile string) {
+	props, err := readMappingProperties(mappingFile)
+	if err != nil {
+		log.Fatalf("Error reading mapping file: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, app := range indexKeys() {
+		pattern := appIndexPattern(app)
+		res, err := client.GetMapping().
+			Index(pattern).
+			IgnoreUnavailable(true).
+			AllowNoIndices(true).
+			Do(ctx)
+		if err != nil {
+			log.Printf("%s: %v\n", pattern, err)
+			continue
+		}
+		if len(res) == 0 {
+			fmt.Printf("%s: does not exist, skipping\n", pattern)
+			continue
+		}
+
+		var indexes []string
+		for index := range res {
+			indexes = append(indexes, index)
+		}
+		sort.Strings(indexes)
+		for _, index := range indexes {
+			updateMapping(ctx, client, index, props, liveProperties(res, index))
+		}
+	}
+}
+
+// updateMapping adds the fields of props missing from current, index's live
+// mapping.
+func updateMapping(ctx context.Context, client *elastic.Client, index string, props, current map[string]interface{}) {
+	var conflicts []string
+	additions := diffProperties(props, current, "", &conflicts)
+	for _, c := range conflicts {
+		fmt.Printf("%s: cannot change type of existing field %s\n", index, c)
+	}
+	if len(additions) == 0 {
+		fmt.Printf("%s: up to date\n", index)
+		return
+	}
+
+	body := map[string]interface{}{"properties": additions}
+	if _, err := client.PutMapping().Index(index).BodyJson(body).Do(ctx); err != nil {
+		log.Printf("%s: error updating mapping: %v\n", index, err)
+		return
+	}
+	added := fieldNames(additions, "")
+	sort.Strings(added)
+	fmt.Printf("%s: added fields %s\n", index, strings.Join(added, ", "))
+}
+
+// liveProperties digs the properties object for index out of a get mapping
+// response.
+func liveProperties(res map[string]interface{}, index string) map[string]interface{} {
+	idx, _ := res[index].(map[string]interface{})
+	mappings, _ := idx["mappings"].(map[string]interface{})
+	props, _ := mappings["properties"].(map[string]interface{})
+	return props
+}
+
+// diffProperties returns the subset of want that is missing from have.
+// Fields present in both with different types are appended to conflicts
+// using their dotted path.
+func diffProperties(want, have map[string]interface{}, prefix string, conflicts *[]string) map[string]interface{} {
+	out := make(map[string]interface{})
+	for name, w := range want {
+		h, ok := have[name]
+		if !ok {
+			out[name] = w
+			continue
+		}
+
+		wf, _ := w.(map[string]interface{})
+		hf, _ := h.(map[string]interface{})
+		wp, wNested := wf["properties"].(map[string]interface{})
+		hp, hNested := hf["properties"].(map[string]interface{})
+		if wNested && hNested {
+			if sub := diffProperties(wp, hp, prefix+name+".", conflicts); len(sub) > 0 {
+				out[name] = map[string]interface{}{"properties": sub}
+			}
+			continue
+		}
+
+		if fmt.Sprint(wf["type"]) != fmt.Sprint(hf["type"]) {
+			*conflicts = append(*conflicts, prefix+name)
+		}
+	}
+	return out
+}
+
+// fieldNames lists the dotted leaf field names of a properties object.
+func fieldNames(props map[string]interface{}, prefix string) []string {
+	var names []string
+	for name, v := range props {
+		f, _ := v.(map[string]interface{})
+		if sub, ok := f["properties"].(map[string]interface{}); ok {
+			names = append(names, fieldNames(sub, prefix+name+".")...)
+			continue
+		}
+		names = append(names, prefix+name)
+	}
+	return names
+}
+
+// checkMappings fetches the live mapping of every app index and reports the
+// fields mapped to different types in different indexes, which break
+// searches across them. It reports whether the mappings are consistent.
+func checkMappings(client *elastic.Client) (bool, error) {
+	var patterns []string
+	for _, app := range indexKeys() {
+		patterns = append(patterns, appIndexPattern(app))
+	}
+	res, err := client.GetMapping().
+		Index(patterns...).
+		IgnoreUnavailable(true).
+		AllowNoIndices(true).
+		Do(context.Background())
+	if err != nil {
+		return false, err
+	}
+
+	// field -> type -> indexes with the field mapped to that type
+	seen := make(map[string]map[string][]string)
+	for index := range res {
+		for field, typ := range mappingTypes(liveProperties(res, index), "", nil) {
+			if seen[field] == nil {
+				seen[field] = make(map[string][]string)
+			}
+			seen[field][typ] = append(seen[field][typ], index)
+		}
+	}
+
+	var fields []string
+	for field, types := range seen {
+		if len(types) > 1 {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	fmt.Printf("Checked %d indexes\n", len(res))
+	for _, field := range fields {
+		fmt.Printf("%s has conflicting types:\n", field)
+		var types []string
+		for typ := range seen[field] {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		for _, typ := range types {
+			indexes := seen[field][typ]
+			sort.Strings(indexes)
+			fmt.Printf("  %s: %s\n", typ, strings.Join(indexes, ", "))
+		}
+	}
+	if len(fields) == 0 {
+		fmt.Println("No conflicting field types")
+	}
+	return len(fields) == 0, nil
+}
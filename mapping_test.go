@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRenderMappingFileInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping string
+		want    string // In the error; empty for a valid mapping.
+	}{
+		{"valid", `{"mappings": {"properties": {"src_ip": {"type": "ip"}}}}`, ""},
+		{"truncated", `{"mappings": {"properties": {"src_ip": {"type": "ip"`, "offset 52 (line 1, column 53)"},
+		{"truncated built-in", string(defaultMapping[:len(defaultMapping)/2]), "unexpected end of JSON input"},
+		{"truncated on second line", "{\n  \"mappings\": {", "line 2, column 16"},
+		{"trailing comma", `{"mappings": {},}`, "offset 17"},
+		{"not an object", `["mappings"]`, "want a JSON object"},
+		{"empty", ``, "unexpected end of JSON input"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, strings.Replace(tt.name, " ", "-", -1)+".json")
+		if err := ioutil.WriteFile(path, []byte(tt.mapping), 0644); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := renderMappingFile(path, "cowrie", "mhn-community-data-cowrie")
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && err == nil:
+			t.Errorf("%s: invalid mapping accepted", tt.name)
+		case tt.want != "" && buf != nil:
+			t.Errorf("%s: body returned along with the error", tt.name)
+		case err != nil && (!strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), path)):
+			t.Errorf("%s: error %q doesn't name the file and %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestUpdateMappingsDatedIndexes(t *testing.T) {
+	defer func(apps []string, prefix, pattern string) {
+		Apps, indexPrefix, indexDatePattern = apps, prefix, pattern
+	}(Apps, indexPrefix, indexDatePattern)
+	Apps, indexPrefix, indexDatePattern = []string{"cowrie", "dionaea"}, "test-", "2006.01"
+
+	var mu sync.Mutex
+	puts := make(map[string]string)
+	client := fakeES(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/test-cowrie-*/_mapping"):
+			w.Write([]byte(`{
+				"test-cowrie-2024.01": {"mappings": {"properties": {"src_ip": {"type": "ip"}}}},
+				"test-cowrie-2024.02": {"mappings": {"properties": {"src_ip": {"type": "ip"}, "dest_port": {"type": "long"}}}}
+			}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			mu.Lock()
+			puts[r.URL.Path] = string(body)
+			mu.Unlock()
+			w.Write([]byte(`{"acknowledged": true}`))
+		}
+	})
+	path := filepath.Join(t.TempDir(), "map.json")
+	mapping := `{"mappings": {"properties": {"src_ip": {"type": "ip"}, "dest_port": {"type": "long"}}}}`
+	if err := ioutil.WriteFile(path, []byte(mapping), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	updateMappings(client, path)
+	want := map[string]string{
+		"/test-cowrie-2024.01/_mapping": `{"properties":{"dest_port":{"type":"long"}}}`,
+	}
+	if !reflect.DeepEqual(puts, want) {
+		t.Errorf("mapping updates %v, want %v", puts, want)
+	}
+}
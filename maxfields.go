@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// OverflowField holds, as a JSON string, the fields moved out of a document
+// that had more than -max-fields.
+const OverflowField = "overflow_fields"
+
+// protectedFields are top level fields never moved to OverflowField: the
+// ones the ingester adds itself, including those of -ecs documents.
+var protectedFields = map[string]bool{
+	"app": true, "@timestamp": true, "ecs": true, "event": true,
+	"observer": true, "hpfeeds": true, "source": true, "destination": true,
+}
+
+func init() {
+	for _, f := range injectedFields {
+		protectedFields[f] = true
+	}
+}
+
+// capFields keeps doc to at most max fields, counted the way ES counts
+// them towards total_fields.limit: every key at every level. Top level
+// fields are kept in name order, protected ones first, until the limit is
+// reached; the rest are moved into OverflowField as a single JSON string.
+// It returns the number of fields the document had and whether any were
+// moved.
+func capFields(doc map[string]interface{}, max int) (int, bool) {
+	total := countFields(doc)
+	if total <= max {
+		return total, false
+	}
+
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := isProtected(keys[i]), isProtected(keys[j])
+		if pi != pj {
+			return pi
+		}
+		return keys[i] < keys[j]
+	})
+
+	kept := 1 // OverflowField itself.
+	overflow := make(map[string]interface{})
+	for _, k := range keys {
+		n := 1 + countFields(doc[k])
+		if isProtected(k) || kept+n <= max {
+			kept += n
+			continue
+		}
+		overflow[k] = doc[k]
+		delete(doc, k)
+	}
+	buf, err := json.Marshal(overflow)
+	if err != nil {
+		buf = []byte("{}")
+	}
+	doc[OverflowField] = string(buf)
+	return total, true
+}
+
+// isProtected reports whether a top level key, which may be a flattened
+// dotted path, belongs to a protected field.
+func isProtected(key string) bool {
+	if i := strings.Index(key, "."); i > 0 {
+		key = key[:i]
+	}
+	return protectedFields[key]
+}
+
+// countFields counts the fields below v: every key of every object,
+// including objects inside arrays.
+func countFields(v interface{}) int {
+	n := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			n += 1 + countFields(child)
+		}
+	case []interface{}:
+		for _, e := range v {
+			n += countFields(e)
+		}
+	}
+	return n
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counters exposed through expvar. They are always maintained, and can be
+// read as JSON from /debug/vars when -metrics-addr is set.
+var (
+	messagesReceived = expvar.NewInt("messages_received_total")
+
+	parseErrors    = expvar.NewInt("parse_errors_total")
+	skippedTotal   = expvar.NewMap("skipped_total")
+	pluginErrors   = expvar.NewInt("plugin_errors_total")
+	duplicateDocs  = expvar.NewInt("duplicate_docs_total")
+	staleSkipped   = expvar.NewInt("stale_skipped_total")
+	threatMatches  = expvar.NewInt("threat_matches_total")
+	idleReconnects = expvar.NewInt("idle_reconnects_total")
+
+	unexpectedChannelMessages = expvar.NewInt("unexpected_channel_messages_total")
+	invalidTypedFields        = expvar.NewMap("invalid_typed_fields_total")
+	archiveDropped            = expvar.NewInt("archive_dropped_total")
+	deadLettered              = expvar.NewInt("dead_lettered_total")
+	indexesRecreated          = expvar.NewInt("indexes_recreated_total")
+	rollovers                 = expvar.NewMap("rollovers_total")
+	rolloverErrors            = expvar.NewInt("rollover_errors_total")
+	staleEvents               = expvar.NewMap("stale_events_total")
+	lateEvents                = expvar.NewMap("late_events_total")
+	published                 = expvar.NewInt("published_total")
+	publishDropped            = expvar.NewInt("publish_dropped_total")
+	renameCollisions          = expvar.NewMap("rename_collisions_total")
+	tagCollisions             = expvar.NewMap("tag_collisions_total")
+	fieldOverflows            = expvar.NewMap("field_overflow_total")
+	appAliased                = expvar.NewMap("app_aliased_total")
+	transformDropped          = expvar.NewInt("transform_dropped_total")
+	transformErrors           = expvar.NewInt("transform_errors_total")
+	pausedDropped             = expvar.NewInt("paused_dropped_total")
+	bufferFullTotal           = expvar.NewInt("buffer_full_total")
+
+	bulkRetriesTotal = expvar.NewInt("bulk_retries_total")
+	retriesExhausted = expvar.NewInt("bulk_retries_exhausted_total")
+
+	retryBudgetExhausted = expvar.NewInt("retry_budget_exhausted_total")
+	conflictFallbacks    = expvar.NewInt("conflict_fallback_total")
+
+	ingestLag = newHistogram("ingest_lag_seconds",
+		1, 5, 10, 30, 60, 300, 900, 3600, 21600, 86400)
+
+	// queueLatency is how long documents wait in processPayloads between
+	// being queued for a bulk request and the request completing.
+	queueLatency = newHistogram("bulk_queue_latency_seconds",
+		0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300)
+
+	// messageSizes is the size of hpfeeds payloads in bytes, per app.
+	messageSizes = newHistogramMap("message_size_bytes",
+		256, 512, 1024, 2048, 4096, 8192, 16384, 65536, 262144, 1048576)
+)
+
+func init() {
+	expvar.Publish("unflushed_docs", expvar.Func(func() interface{} {
+		return unflushedDocs()
+	}))
+	expvar.Publish("circuit_breaker_state", expvar.Func(func() interface{} {
+		return esBreaker.State()
+	}))
+	expvar.Publish("bulk_inflight", expvar.Func(func() interface{} {
+		return atomic.LoadInt64(&inflight)
+	}))
+	expvar.Publish("paused", expvar.Func(func() interface{} {
+		return isPaused()
+	}))
+	expvar.Publish("buffer_full", expvar.Func(func() interface{} {
+		return atomic.LoadInt32(&bufferFull) == 1
+	}))
+}
+
+// status returns the current state of the ingester for /status.
+func status() map[string]interface{} {
+	return map[string]interface{}{
+		"version":         Version,
+		"circuit_breaker": esBreaker.State(),
+		"paused":          isPaused(),
+		"hpfeeds":         brokerStatus(),
+		"delivery":        deliveryStatus(),
+	}
+}
+
+// serveHealth responds 200 while connected to every hpfeeds broker and 503
+// otherwise. A healthy response lists the state of each channel's
+// subscription after the "ok".
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	if down := disconnectedBrokers(); len(down) > 0 {
+		http.Error(w, "hpfeeds not connected: "+strings.Join(down, ", "), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+	for _, b := range brokers {
+		subs := b.state.Subscriptions()
+		for _, ch := range b.channels {
+			if state, ok := subs[ch]; ok {
+				fmt.Fprintf(w, "%s %s %s\n", b.name, ch, state)
+			}
+		}
+	}
+}
+
+// serveStatus writes the status as JSON.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status())
+}
+
+// serveConfig writes the effective configuration as JSON.
+func serveConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(effectiveConfig())
+}
+
+// histogram is an expvar.Var that counts observations into buckets by upper
+// bound. Bucket counts are cumulative, as in Prometheus histograms.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []int64 // One per bound, plus +Inf.
+	count  int64
+	sum    float64
+}
+
+// newHistogram creates and publishes a histogram with the given ascending
+// bucket upper bounds.
+func newHistogram(name string, bounds ...float64) *histogram {
+	h := &histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+	expvar.Publish(name, h)
+	return h
+}
+
+// Observe records a single value.
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+// String renders the histogram as JSON for expvar.
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `{"count": %d, "sum": %s, "buckets": {`, h.count, strconv.FormatFloat(h.sum, 'f', -1, 64))
+	var cum int64
+	for i, bound := range h.bounds {
+		cum += h.counts[i]
+		fmt.Fprintf(&b, `"%s": %d, `, strconv.FormatFloat(bound, 'f', -1, 64), cum)
+	}
+	fmt.Fprintf(&b, `"+Inf": %d}}`, h.count)
+	return b.String()
+}
+
+// Quantile estimates the q quantile (0 to 1) as the upper bound of the
+// bucket it falls in, or +Inf past the last bound. It's 0 without any
+// observations.
+func (h *histogram) Quantile(q float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.count)))
+	var cum int64
+	for i, bound := range h.bounds {
+		cum += h.counts[i]
+		if cum >= rank {
+			return bound
+		}
+	}
+	return math.Inf(1)
+}
+
+// Mean returns the average of the observations, or 0 without any.
+func (h *histogram) Mean() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / float64(h.count)
+}
+
+// histogramMap is an expvar.Var holding a histogram per label, such as per
+// app, each created with the same bounds on first use.
+type histogramMap struct {
+	mu     sync.Mutex
+	bounds []float64
+	m      map[string]*histogram
+}
+
+// newHistogramMap creates and publishes a histogram map with the given
+// ascending bucket upper bounds.
+func newHistogramMap(name string, bounds ...float64) *histogramMap {
+	hm := &histogramMap{bounds: bounds, m: make(map[string]*histogram)}
+	expvar.Publish(name, hm)
+	return hm
+}
+
+// Observe records a single value under label.
+func (hm *histogramMap) Observe(label string, v float64) {
+	hm.mu.Lock()
+	h, ok := hm.m[label]
+	if !ok {
+		h = &histogram{bounds: hm.bounds, counts: make([]int64, len(hm.bounds)+1)}
+		hm.m[label] = h
+	}
+	hm.mu.Unlock()
+	h.Observe(v)
+}
+
+// Labels returns the labels observed so far, sorted.
+func (hm *histogramMap) Labels() []string {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	labels := make([]string, 0, len(hm.m))
+	for label := range hm.m {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Get returns the histogram of label, or nil if nothing was observed under
+// it.
+func (hm *histogramMap) Get(label string) *histogram {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	return hm.m[label]
+}
+
+// String renders the histograms as a JSON object keyed by label.
+func (hm *histogramMap) String() string {
+	var b strings.Builder
+	b.WriteString("{")
+	for i, label := range hm.Labels() {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%q: %s", label, hm.Get(label))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// logMessageSizes logs the payload size distribution of every app every
+// interval, since startup. It returns on shutdown.
+func logMessageSizes(interval time.Duration) {
+	for !sleepOrShutdown(interval) {
+		for _, app := range messageSizes.Labels() {
+			h := messageSizes.Get(app)
+			log.Printf("Message sizes for %s: mean=%.0fB p50<=%sB p99<=%sB\n", app, h.Mean(),
+				strconv.FormatFloat(h.Quantile(0.5), 'f', -1, 64), strconv.FormatFloat(h.Quantile(0.99), 'f', -1, 64))
+		}
+	}
+}
+
+// serveMetrics starts an HTTP server on addr exposing the expvar metrics, a
+// JSON status summary at /status, the effective configuration at /config, a
+// health check at /healthz, and /pause and /resume to control ES writes. A
+// dedicated mux is used so nothing else registered on the default mux leaks
+// out on this listener.
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/status", serveStatus)
+	mux.HandleFunc("/healthz", serveHealth)
+	mux.HandleFunc("/config", serveConfig)
+	mux.HandleFunc("/pause", servePause(true))
+	mux.HandleFunc("/resume", servePause(false))
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Printf("Serving metrics on %s/debug/vars\n", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("Metrics server stopped: %v\n", err)
+		return
+	}
+	<-stopped
+}
+
+// servePprof serves the net/http/pprof handlers on addr, on a mux of their
+// own so they're never exposed on -metrics-addr by accident.
+func servePprof(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Serving pprof on %s/debug/pprof/\n", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("pprof server stopped: %v\n", err)
+	}
+}
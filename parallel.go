@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// InitWorkers is the default for -init-concurrency, how many index
+// operations -init runs at once.
+const InitWorkers = 4
+
+// runParallel calls fn for every item using at most workers goroutines and
+// returns the errors in the order of items, nil for those that succeeded.
+func runParallel(items []string, workers int, fn func(string) error) []error {
+	errs := make([]error, len(items))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(items); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = fn(items[i])
+			}
+		}()
+	}
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
+
+// skipped is returned by an index operation that had nothing to do, such as
+// creating an index that already exists, with the reason why.
+type skipped string
+
+func (s skipped) Error() string { return string(s) }
+
+// runIndexOps runs op on every index with -init-concurrency workers, then
+// reports the outcome for each and how many were done, skipped and failed.
+// verb describes a successful op, e.g. "Created". A failure doesn't stop
+// the other indexes; it returns the number of failures.
+func runIndexOps(verb string, indexes []string, op func(string) error) int {
+	errs := runParallel(indexes, initConcurrency, op)
+
+	done, skips, failed := 0, 0, 0
+	for i, err := range errs {
+		switch err := err.(type) {
+		case nil:
+			fmt.Printf("%s index %s\n", verb, indexes[i])
+			done++
+		case skipped:
+			fmt.Printf("Skipped index %s: %s\n", indexes[i], err)
+			skips++
+		default:
+			log.Printf("%s index %s failed: %v\n", verb, indexes[i], err)
+			failed++
+		}
+	}
+	fmt.Printf("%s %d, skipped %d, failed %d of %d indexes\n", verb, done, skips, failed, len(indexes))
+	return failed
+}
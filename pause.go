@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// Policies for documents arriving while paused, set with -pause-policy.
+const (
+	PauseHold = "hold"
+	PauseDrop = "drop"
+)
+
+// paused is 1 while ES writes are paused, e.g. for cluster maintenance.
+var paused int32
+
+// pauseChanged wakes processPayloads up when the pause state changes, so
+// held documents are flushed on resume even if no new message arrives.
+var pauseChanged = make(chan struct{}, 1)
+
+func isPaused() bool {
+	return atomic.LoadInt32(&paused) == 1
+}
+
+// setPaused pauses or resumes ES writes and reports whether that changed
+// anything.
+func setPaused(p bool) bool {
+	var v int32
+	if p {
+		v = 1
+	}
+	if atomic.SwapInt32(&paused, v) == v {
+		return false
+	}
+	if p {
+		log.Printf("Paused ES writes with -pause-policy %s\n", pausePolicy)
+	} else {
+		log.Println("Resumed ES writes")
+	}
+	select {
+	case pauseChanged <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// handleSIGUSR1 toggles the pause every time the process receives a
+// SIGUSR1. It returns on shutdown.
+func handleSIGUSR1() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+	for {
+		select {
+		case <-usr1:
+			setPaused(!isPaused())
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// servePause returns a handler for POST /pause or /resume that sets the
+// pause to p and responds with the resulting state.
+func servePause(p bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		setPaused(p)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"paused": isPaused()})
+	}
+}
+
+// waitForResume blocks while ES writes are paused, until they're resumed or
+// shutdown starts.
+func waitForResume() {
+	for isPaused() {
+		select {
+		case <-pauseChanged:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
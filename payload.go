@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+// Payload holds a small portion of data expected in each hpfeeds message. This
+// data is minimum required and needed for use in creating new fields.
+type Payload struct {
+	App string `json:"app"` // Honeypot software type
+
+	DestLatitude  float64 `json:"dest_latitude"`
+	DestLongitude float64 `json:"dest_longitude"`
+	SrcLatitude   float64 `json:"src_latitude"`
+	SrcLongitude  float64 `json:"src_longitude"`
+
+	// Fields promoted to stable types in the indexed document, whatever
+	// type the honeypot sent them as.
+	SrcIP    flexString `json:"src_ip"`
+	DestIP   flexString `json:"dest_ip"`
+	SrcPort  flexInt    `json:"src_port"`
+	DestPort flexInt    `json:"dest_port"`
+}
+
+// decodePayload parses buf in the -payload-format into a document, and
+// fills p from it.
+func decodePayload(buf []byte, p *Payload) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	var err error
+	switch payloadFormat {
+	case FormatCEF:
+		doc, err = parseCEF(string(buf))
+	case FormatLEEF:
+		doc, err = parseLEEF(string(buf))
+	default:
+		if err := json.Unmarshal(buf, p); err != nil {
+			return nil, err
+		}
+		if err := unmarshalDoc(buf, &doc); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, p.reload(doc)
+}
+
+// JSON number handling for -json-numbers.
+const (
+	NumbersExact = "exact"
+	NumbersFloat = "float"
+)
+
+// unmarshalDoc parses the JSON object in buf into doc. With -json-numbers
+// exact, numbers are kept as json.Number, so integers such as large ids keep
+// every digit rather than being rounded to a float64 on the way through.
+// With float they're float64s, as json.Unmarshal makes them.
+func unmarshalDoc(buf []byte, doc *map[string]interface{}) error {
+	if jsonNumbers == NumbersFloat {
+		return json.Unmarshal(buf, doc)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(doc); err != nil {
+		return err
+	}
+	// Like json.Unmarshal, refuse anything after the object.
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level value")
+	}
+	return nil
+}
+
+// reload re-reads p from doc, after the document's fields were renamed.
+func (p *Payload) reload(doc map[string]interface{}) error {
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	*p = Payload{}
+	return json.Unmarshal(buf, p)
+}
+
+// validCoords reports whether lat and lon are usable coordinates. Honeypots
+// without geo data leave both at zero, so 0,0 counts as missing.
+func validCoords(lat, lon float64) bool {
+	if lat == 0 && lon == 0 {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// hasGeo reports whether the source has usable coordinates.
+func (p *Payload) hasGeo() bool {
+	return validCoords(p.SrcLatitude, p.SrcLongitude)
+}
+
+// addGeohashes adds src_geohash and dest_geohash fields with the given
+// precision for whichever of the source and destination have valid
+// coordinates.
+func (p *Payload) addGeohashes(doc map[string]interface{}, precision uint) {
+	if validCoords(p.SrcLatitude, p.SrcLongitude) {
+		doc["src_geohash"] = geohash.EncodeWithPrecision(p.SrcLatitude, p.SrcLongitude, precision)
+	}
+	if validCoords(p.DestLatitude, p.DestLongitude) {
+		doc["dest_geohash"] = geohash.EncodeWithPrecision(p.DestLatitude, p.DestLongitude, precision)
+	}
+}
+
+// promote overwrites the typed fields in doc with their parsed values: IPs
+// in canonical form, ports as integers and app as a string. Values that
+// can't be parsed are removed so they can't fail the whole document against
+// the ip and integer mappings.
+func (p *Payload) promote(doc map[string]interface{}) {
+	if _, ok := doc["app"]; ok {
+		doc["app"] = p.App
+	}
+	promoteIP(doc, "src_ip", p.SrcIP)
+	promoteIP(doc, "dest_ip", p.DestIP)
+	promotePort(doc, "src_port", p.SrcPort)
+	promotePort(doc, "dest_port", p.DestPort)
+}
+
+func promoteIP(doc map[string]interface{}, field string, value flexString) {
+	if _, ok := doc[field]; !ok {
+		return
+	}
+	if ip := net.ParseIP(string(value)); ip != nil {
+		doc[field] = ip.String()
+		return
+	}
+	delete(doc, field)
+	invalidTypedFields.Add(field, 1)
+}
+
+func promotePort(doc map[string]interface{}, field string, value flexInt) {
+	if _, ok := doc[field]; !ok {
+		return
+	}
+	if value.valid && value.n >= 0 && value.n <= 65535 {
+		doc[field] = value.n
+		return
+	}
+	delete(doc, field)
+	invalidTypedFields.Add(field, 1)
+}
+
+// flexInt accepts an integer given as a JSON number or a quoted string.
+// Anything else leaves it invalid rather than failing the whole payload.
+type flexInt struct {
+	n     int64
+	valid bool
+}
+
+func (f *flexInt) UnmarshalJSON(b []byte) error {
+	b = bytes.Trim(b, `"`)
+	if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		*f = flexInt{n, true}
+		return nil
+	}
+	if v, err := strconv.ParseFloat(string(b), 64); err == nil && v == float64(int64(v)) {
+		*f = flexInt{int64(v), true}
+		return nil
+	}
+	*f = flexInt{}
+	return nil
+}
+
+// flexString accepts any JSON value, keeping it only if it's a string, so a
+// mistyped field doesn't fail the whole payload.
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = ""
+	}
+	*f = flexString(s)
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDecodePayloadNumbers(t *testing.T) {
+	tests := []struct {
+		name    string
+		numbers string
+		in      string
+		want    string
+	}{
+		{"large id", NumbersExact, `{"id":9007199254740993}`, `{"id":9007199254740993}`},
+		{"max int64", NumbersExact, `{"id":9223372036854775807}`, `{"id":9223372036854775807}`},
+		{"port stays integer", NumbersExact, `{"port":80}`, `{"port":80}`},
+		{"fraction", NumbersExact, `{"score":0.25}`, `{"score":0.25}`},
+		{"nested", NumbersExact, `{"a":{"id":12345678901234567}}`, `{"a":{"id":12345678901234567}}`},
+		{"float rounds", NumbersFloat, `{"id":9007199254740993}`, `{"id":9007199254740992}`},
+	}
+	defer func(old string) { jsonNumbers = old }(jsonNumbers)
+	for _, tt := range tests {
+		jsonNumbers = tt.numbers
+		var p Payload
+		doc, err := decodePayload([]byte(tt.in), &p)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		out, err := json.Marshal(doc)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(out) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, out, tt.want)
+		}
+	}
+}
+
+func TestDecodePayloadTrailingData(t *testing.T) {
+	var p Payload
+	if _, err := decodePayload([]byte(`{"id":1} {"id":2}`), &p); err == nil {
+		t.Error("data after the object accepted")
+	}
+}
+
+func TestPromote(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]interface{}
+	}{
+		{"typed", `{"app": "cowrie", "src_ip": "192.0.2.1", "src_port": 22}`,
+			map[string]interface{}{"app": "cowrie", "src_ip": "192.0.2.1", "src_port": int64(22)}},
+		{"port as string", `{"dest_port": "445"}`,
+			map[string]interface{}{"dest_port": int64(445)}},
+		{"port as float", `{"dest_port": 80.0}`,
+			map[string]interface{}{"dest_port": int64(80)}},
+		{"ipv6 canonical", `{"src_ip": "2001:DB8:0:0::1"}`,
+			map[string]interface{}{"src_ip": "2001:db8::1"}},
+		{"invalid ip dropped", `{"src_ip": "not an ip", "dest_ip": 12}`,
+			map[string]interface{}{}},
+		{"invalid ports dropped", `{"src_port": "ssh", "dest_port": 70000}`,
+			map[string]interface{}{}},
+		{"negative port dropped", `{"src_port": -1}`,
+			map[string]interface{}{}},
+		{"missing stays missing", `{"other": "x"}`,
+			map[string]interface{}{"other": "x"}},
+	}
+	for _, tt := range tests {
+		var p Payload
+		doc, err := decodePayload([]byte(tt.in), &p)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		p.promote(doc)
+		if !reflect.DeepEqual(doc, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, doc, tt.want)
+		}
+	}
+}
+
+func TestAddGeohashes(t *testing.T) {
+	tests := []struct {
+		name      string
+		p         Payload
+		precision uint
+		want      map[string]interface{}
+	}{
+		{"jutland", Payload{SrcLatitude: 57.64911, SrcLongitude: 10.40744}, 11,
+			map[string]interface{}{"src_geohash": "u4pruydqqvj"}},
+		{"short precision", Payload{SrcLatitude: 57.64911, SrcLongitude: 10.40744}, 5,
+			map[string]interface{}{"src_geohash": "u4pru"}},
+		{"both ends", Payload{SrcLatitude: 42.6, SrcLongitude: -5.6, DestLatitude: -25.382708, DestLongitude: -49.265506}, 5,
+			map[string]interface{}{"src_geohash": "ezs42", "dest_geohash": "6gkzw"}},
+		{"zero is missing", Payload{DestLatitude: 42.6, DestLongitude: -5.6}, 5,
+			map[string]interface{}{"dest_geohash": "ezs42"}},
+		{"out of range", Payload{SrcLatitude: 91, SrcLongitude: 10}, 5,
+			map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		doc := make(map[string]interface{})
+		tt.p.addGeohashes(doc, tt.precision)
+		if !reflect.DeepEqual(doc, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, doc, tt.want)
+		}
+	}
+}
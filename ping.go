@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// runPing checks that ES answers a ping, and with -ping-url that a running
+// instance's health endpoint returns 200, all within timeout. Unlike
+// runChecks it doesn't touch hpfeeds and leaves out the client's own
+// sniffing and health checks, so it's cheap enough to run every few seconds.
+func runPing(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client, err := newElasticClient(
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+	)
+	if err != nil {
+		return fmt.Errorf("elasticsearch: %v", err)
+	}
+	defer client.Stop()
+
+	url := elasticURL
+	if cloudID != "" {
+		url, _ = decodeCloudID(cloudID)
+	}
+	if _, _, err := client.Ping(url).Do(ctx); err != nil {
+		return fmt.Errorf("elasticsearch: %v", err)
+	}
+
+	if pingURL == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pingURL, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %v", pingURL, err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %v", pingURL, err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s: %s", pingURL, res.Status, body)
+	}
+	return nil
+}
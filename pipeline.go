@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// pipelineSet maps apps to the ES ingest pipeline their documents go through.
+// Apps without an entry use the default, which may be empty for none.
+type pipelineSet struct {
+	def   string
+	byApp map[string]string
+}
+
+// parsePipelines parses -pipeline arguments. A bare name sets the default
+// pipeline, app=name sets the pipeline for one app.
+func parsePipelines(args []string) (pipelineSet, error) {
+	ps := pipelineSet{byApp: make(map[string]string)}
+	for _, arg := range args {
+		app, name, ok := splitKeyValue(arg)
+		if !ok {
+			if ps.def != "" {
+				return ps, fmt.Errorf("more than one default pipeline: %q and %q", ps.def, arg)
+			}
+			ps.def = arg
+			continue
+		}
+		if name == "" {
+			return ps, fmt.Errorf("invalid pipeline %q, want app=name", arg)
+		}
+		if _, dup := ps.byApp[app]; dup {
+			return ps, fmt.Errorf("pipeline for %s given more than once", app)
+		}
+		ps.byApp[app] = name
+	}
+	return ps, nil
+}
+
+// For returns the pipeline app's documents should use, or "" for none.
+func (ps pipelineSet) For(app string) string {
+	if name, ok := ps.byApp[app]; ok {
+		return name
+	}
+	return ps.def
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+)
+
+// Processor is implemented by external plugins that want to modify documents
+// before they are indexed. Process receives the fully enriched document and
+// returns the document to index in its place. On error the returned
+// document is ignored, but doc isn't copied for the plugin: any changes it
+// made to doc before failing stay, so a plugin that can fail halfway should
+// build its changes on a copy.
+type Processor interface {
+	Process(doc map[string]interface{}) (map[string]interface{}, error)
+}
+
+// ProcessorSymbol is the name of the exported variable every plugin must
+// provide. Its type must implement Processor.
+const ProcessorSymbol = "Processor"
+
+// namedProcessor keeps the plugin file name around for logging.
+type namedProcessor struct {
+	name string
+	Processor
+}
+
+// loadPlugins opens every .so file in dir, in lexical order, and returns the
+// processors they export. Plugins that fail to load are logged and skipped.
+func loadPlugins(dir string) []namedProcessor {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Printf("Error reading plugin dir: %v\n", err)
+		return nil
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".so") {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var procs []namedProcessor
+	for _, name := range names {
+		p, err := loadPlugin(filepath.Join(dir, name))
+		if err != nil {
+			log.Printf("Error loading plugin %s: %v\n", name, err)
+			continue
+		}
+		log.Printf("Loaded plugin %s\n", name)
+		procs = append(procs, namedProcessor{name, p})
+	}
+	return procs
+}
+
+func loadPlugin(path string) (Processor, error) {
+	plug, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := plug.Lookup(ProcessorSymbol)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := sym.(Processor)
+	if !ok {
+		return nil, fmt.Errorf("symbol %s of type %T does not implement Processor", ProcessorSymbol, sym)
+	}
+	return p, nil
+}
+
+// runProcessors passes doc through each processor in turn. A processor that
+// errors or panics is skipped for this document and the chain continues with
+// doc, including whatever that processor changed in it before failing.
+func runProcessors(procs []namedProcessor, doc map[string]interface{}) map[string]interface{} {
+	for _, p := range procs {
+		out, err := safeProcess(p, doc)
+		if err != nil {
+			pluginErrors.Add(1)
+			pluginLog.Printf("Plugin %s failed: %v\n", p.name, err)
+			continue
+		}
+		if out != nil {
+			doc = out
+		}
+	}
+	return doc
+}
+
+func safeProcess(p Processor, doc map[string]interface{}) (out map[string]interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return p.Process(doc)
+}
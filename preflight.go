@@ -0,0 +1,423 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// preflight collects configuration problems found at startup. Errors are
+// fatal; warnings are printed but startup continues.
+type preflight struct {
+	errors   []string
+	warnings []string
+}
+
+func (p *preflight) errorf(format string, v ...interface{}) {
+	p.errors = append(p.errors, fmt.Sprintf(format, v...))
+}
+
+func (p *preflight) warnf(format string, v ...interface{}) {
+	p.warnings = append(p.warnings, fmt.Sprintf(format, v...))
+}
+
+// runPreflight validates the app list, index naming and flag combinations,
+// printing a summary of anything wrong and exiting non-zero if any problem is
+// fatal. This makes a bad deployment fail at startup rather than misbehave
+// at runtime.
+func runPreflight() {
+	var p preflight
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// App list.
+	seen := map[string]bool{}
+	for _, app := range Apps {
+		if seen[app] {
+			p.warnf("app %q is listed more than once", app)
+		}
+		seen[app] = true
+		if sanitizeIndexPart(app) != app {
+			p.errorf("app %q is not a valid index name component", app)
+		}
+	}
+
+	// Brokers and their channels, which also name indexes with -index-by
+	// channel.
+	bs, err := parseBrokers()
+	if err != nil && !listenOnly {
+		p.errorf("-broker: %v", err)
+	}
+	if listenOnly {
+		if listenAddr == "" {
+			p.errorf("-listen-only needs -listen")
+		}
+		bs = nil
+		if publishChannel != "" {
+			p.errorf("-publish-channel needs an hpfeeds broker, not -listen-only")
+		}
+	} else if len(brokerURLs) > 0 {
+		for _, name := range []string{"host", "port", "ident", "secret", "channel"} {
+			if set[name] {
+				p.warnf("-%s is ignored when -broker is given", name)
+			}
+		}
+	} else if len(parseChannels(channel)) == 0 {
+		p.errorf("no hpfeeds channel given")
+	}
+	if indexBy != "app" && indexBy != "channel" {
+		p.errorf("-index-by must be app or channel, not %q", indexBy)
+	}
+
+	// Index naming.
+	if strings.Contains(indexPrefix, ":") {
+		p.errorf("-index-prefix %q names a remote cluster, which ES can't write to; point -elastic-url at a node of that cluster instead", indexPrefix)
+	} else if indexPrefix == "" || indexPrefix != strings.ToLower(indexPrefix) || illegalChars.MatchString(indexPrefix) ||
+		strings.ContainsAny(indexPrefix[:1], "-_+.") {
+		p.errorf("-index-prefix %q is not a valid index name", indexPrefix)
+	}
+
+	if indexDateMath != "" {
+		if !validDateMath(indexDateMath) {
+			p.errorf("-index-date-math %q is not a date math expression like {now/d}", indexDateMath)
+		}
+		if indexDatePattern != "" {
+			p.errorf("-index-date-math and -index-date-pattern can't be used together")
+		}
+	}
+
+	if writeAlias && (indexDatePattern != "" || indexDateMath != "" || indexTemplate != "") {
+		p.errorf("-write-alias can't be used with -index-date-pattern, -index-date-math or -index-template")
+	}
+	if rolloverEnabled() {
+		if !writeAlias {
+			p.errorf("-rollover-max-age, -rollover-max-size and -rollover-max-docs need -write-alias")
+		}
+		if rolloverMaxAge < 0 || rolloverMaxDocs < 0 {
+			p.errorf("-rollover-max-age and -rollover-max-docs can't be negative")
+		}
+		if rolloverMaxSize != "" && !validByteSize.MatchString(rolloverMaxSize) {
+			p.errorf("-rollover-max-size %q is not a size like 50gb", rolloverMaxSize)
+		}
+		if rolloverInterval <= 0 {
+			p.errorf("-rollover-interval must be positive")
+		}
+	}
+
+	if quarantineIndex != "" && sanitizeIndexPart(quarantineIndex) != quarantineIndex {
+		p.errorf("-quarantine-index %q is not a valid index name", quarantineIndex)
+	}
+
+	if noGeoIndex != "" && sanitizeIndexPart(noGeoIndex) != noGeoIndex {
+		p.errorf("-no-geo-index %q is not a valid index name", noGeoIndex)
+	}
+
+	if lateIndex != "" {
+		if sanitizeIndexPart(lateIndex) != lateIndex {
+			p.errorf("-late-index %q is not a valid index name", lateIndex)
+		}
+		if maxEventAge == 0 {
+			p.warnf("-late-index has no effect without -max-event-age")
+		}
+	}
+
+	// A missing mapping file falls back to the built-in one, which is
+	// likely not what was meant.
+	if mappingFile != "" {
+		if _, err := os.Stat(mappingFile); os.IsNotExist(err) {
+			p.warnf("mapping file %s not found, using the built-in default mapping", mappingFile)
+		} else if err != nil {
+			p.errorf("mapping file: %v", err)
+		} else if _, err := readMappingFile(mappingFile); err != nil {
+			// Fatal when it's about to be used to create indexes. Otherwise
+			// the runtime users of the mapping, such as -index-check, log
+			// and carry on, unless -init-strict.
+			if initMapping || initStrict {
+				p.errorf("mapping file %v", err)
+			} else {
+				p.warnf("mapping file %v", err)
+			}
+		}
+	}
+
+	if indexShards < 1 || indexReplicas < 0 {
+		p.errorf("-index-shards must be at least 1 and -index-replicas can't be negative")
+	}
+
+	// Flag values and combinations.
+	if bulkAction != "index" && bulkAction != "create" {
+		p.errorf("-bulk-action must be index or create, not %q", bulkAction)
+	}
+	if initOverride && !initMapping {
+		p.errorf("-init-override has no effect without -init")
+	}
+	if deleteList != "" {
+		if !initOverride {
+			p.errorf("-delete-indexes has no effect without -init-override")
+		}
+		for _, pattern := range strings.Split(deleteList, ",") {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				p.errorf("-delete-indexes pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	if mappingComments && mappingFile == "" {
+		p.warnf("-mapping-allow-comments has no effect without -mapping-file")
+	}
+	if parseLogLimit > 0 && parseLogInterval <= 0 {
+		p.errorf("-parse-log-interval must be positive")
+	}
+	if initConcurrency < 1 {
+		p.errorf("-init-concurrency must be at least 1")
+	}
+	if initOverride && indexTemplate != "" {
+		p.warnf("-init-override has no effect with -index-template")
+	}
+	if set["confirm"] && purgeOlderThan == 0 {
+		p.warnf("-confirm has no effect without -purge-older-than")
+	}
+	if set["flatten-depth"] && !flattenDocs {
+		p.warnf("-flatten-depth has no effect without -flatten")
+	}
+	if set["generate-mapping-out"] && genMapping == "" {
+		p.warnf("-generate-mapping-out has no effect without -generate-mapping")
+	}
+	if set["archive-retention-days"] && archiveDir == "" {
+		p.warnf("-archive-retention-days has no effect without -archive-dir")
+	}
+	if cloudID != "" {
+		if _, err := decodeCloudID(cloudID); err != nil {
+			p.errorf("-cloud-id: %v", err)
+		}
+		if set["elastic-url"] {
+			p.warnf("-elastic-url is ignored when -cloud-id is set")
+		}
+		if set["es-sniff"] && esSniff {
+			p.warnf("-es-sniff is ignored when -cloud-id is set")
+		}
+	}
+	if _, err := parseHeaders(elasticHeaders); err != nil {
+		p.errorf("-elastic-header: %v", err)
+	}
+	if ps, err := parsePipelines(pipelineArgs); err != nil {
+		p.errorf("-pipeline: %v", err)
+	} else {
+		for app := range ps.byApp {
+			if !seen[app] {
+				p.warnf("-pipeline given for unknown app %q", app)
+			}
+		}
+	}
+	if r, err := parseRenames(renameList, renameFile); err != nil {
+		p.errorf("-rename: %v", err)
+	} else {
+		for _, c := range r.collisions() {
+			p.warnf("-rename: %s", c)
+		}
+	}
+	if a, err := parseAppAliases(appAliasList, appAliasFile); err != nil {
+		p.errorf("-app-alias: %v", err)
+	} else {
+		for from, to := range a {
+			if !seen[to] {
+				p.warnf("-app-alias %s=%s aliases to unknown app %q", from, to, to)
+			}
+		}
+	}
+	if transformsFile != "" {
+		if rules, err := loadAppRules(transformsFile); err != nil {
+			p.errorf("-transforms-file: %v", err)
+		} else {
+			for app := range rules {
+				if !seen[app] {
+					p.warnf("-transforms-file has rules for unknown app %q", app)
+				}
+			}
+		}
+	}
+	if versionField != "" {
+		if idField == "" {
+			p.errorf("-version-field needs -id-field, ES only versions documents with a known _id")
+		}
+		if bulkAction == "create" {
+			p.errorf("-version-field can't be used with -bulk-action create, which only supports internal versioning")
+		}
+	}
+	if ecsMode && (initMapping || updateMap) && mappingFile == "" {
+		p.warnf("-ecs documents don't match the default mapping, use -mapping-file map-ecs.json")
+	}
+	if esMaxIdleConns < 0 || esDialTimeout < 0 || esResponseTimeout < 0 {
+		p.errorf("-es-max-idle-conns, -es-dial-timeout and -es-response-timeout must not be negative")
+	}
+	if pprofAddr != "" && pprofAddr == metricsAddr {
+		p.errorf("-pprof-addr must differ from -metrics-addr")
+	}
+	if backfillBatch <= 0 {
+		p.errorf("-backfill-batch must be positive")
+	}
+	if backfillQuery != "" && backfillIndex == "" {
+		p.warnf("-backfill-query has no effect without -backfill")
+	}
+	if validateSample < 0 || validateSample > 10000 {
+		p.errorf("-validate-docs must be between 0 and 10000")
+	}
+	if asnDB != "" {
+		if _, err := os.Stat(asnDB); err != nil {
+			p.errorf("-asn-db: %v", err)
+		}
+	}
+	if maxFields < 0 {
+		p.errorf("-max-fields must not be negative")
+	}
+	if _, err := parseTags(tagArgs); err != nil {
+		p.errorf("-tag: %v", err)
+	}
+	if _, err := parseTimestampSources(timestampSourceList); err != nil {
+		p.errorf("-timestamp-sources: %v", err)
+	}
+	if set["timestamp-fields"] && len(parseTimestampFields(timestampFields)) == 0 {
+		p.errorf("-timestamp-fields must name at least one field")
+	}
+	if tagPrecedence != "tag" && tagPrecedence != "payload" {
+		p.errorf("-tag-precedence must be tag or payload, not %q", tagPrecedence)
+	}
+	if geohashPrecision > 12 {
+		p.errorf("-geohash-precision must be between 0 and 12")
+	}
+	if relayOnly && publishChannel == "" {
+		p.errorf("-relay-only requires -publish-channel")
+	}
+	for _, c := range allChannels(bs) {
+		if publishChannel != "" && c == publishChannel {
+			p.errorf("-publish-channel %q is also subscribed to, which would loop", c)
+		}
+	}
+	if benchCount < 0 || benchRate < 0 {
+		p.errorf("-bench and -bench-rate must not be negative")
+	}
+	if (set["bench-rate"] || benchES) && benchCount == 0 {
+		p.warnf("-bench-rate and -bench-es have no effect without -bench")
+	}
+	if replayDeadPath != "" {
+		if _, err := os.Stat(replayDeadPath); err != nil {
+			p.errorf("-replay-dead-letter: %v", err)
+		}
+		if sameFile(replayFailedPath(), replayDeadPath) {
+			p.errorf("-dead-letter-file must differ from -replay-dead-letter")
+		}
+	}
+	if compression != "" {
+		if !validCompression(compression) {
+			p.errorf("-compression must be none, gzip or zstd, not %q", compression)
+		} else if archiveDir == "" && recordFile == "" {
+			p.warnf("-compression has no effect without -archive-dir or -record")
+		}
+	}
+	if recordFile != "" && recordFile == replayFile {
+		p.errorf("-record and -replay can't use the same file")
+	}
+	if listenAddr != "" {
+		if _, _, err := net.SplitHostPort(listenAddr); err != nil {
+			p.errorf("-listen: %v", err)
+		}
+		if listenChannel == "" {
+			p.errorf("-listen-channel must not be empty")
+		}
+	}
+	if replayFile != "" {
+		if _, err := os.Stat(replayFile); err != nil {
+			p.errorf("-replay: %v", err)
+		}
+	}
+	if subscribeTimeout < 0 {
+		p.errorf("-subscribe-timeout must not be negative")
+	}
+	if maxMessages < 0 {
+		p.errorf("-max-messages must not be negative")
+	}
+	if startupMessageTimeout < 0 {
+		p.errorf("-startup-message-timeout must not be negative")
+	}
+	if pausePolicy != PauseHold && pausePolicy != PauseDrop {
+		p.errorf("-pause-policy must be hold or drop, not %q", pausePolicy)
+	}
+	if pauseBuffer < BulkSize {
+		p.errorf("-pause-buffer must be at least %d", BulkSize)
+	}
+	switch payloadFormat {
+	case FormatJSON, FormatCEF, FormatLEEF:
+	default:
+		p.errorf("-payload-format must be json, cef or leef, not %q", payloadFormat)
+	}
+	if jsonNumbers != NumbersExact && jsonNumbers != NumbersFloat {
+		p.errorf("-json-numbers must be exact or float, not %q", jsonNumbers)
+	}
+	if maxBufferedDocs < 0 {
+		p.errorf("-max-buffered-docs must not be negative")
+	} else if maxBufferedDocs > 0 && maxBufferedDocs < BulkSize {
+		p.warnf("-max-buffered-docs %d is below the bulk size of %d, every flush will be smaller", maxBufferedDocs, BulkSize)
+	} else if maxBufferedDocs > 0 && pausePolicy == PauseHold && maxBufferedDocs < pauseBuffer {
+		p.warnf("-max-buffered-docs %d is below -pause-buffer %d, a long pause stops reading from hpfeeds instead of dropping", maxBufferedDocs, pauseBuffer)
+	}
+	if logDedupWindow < 0 {
+		p.errorf("-log-dedup-window must not be negative")
+	}
+	if idleFlush < 0 {
+		p.errorf("-idle-flush must not be negative")
+	}
+	if shutdownTimeout <= 0 {
+		p.errorf("-shutdown-timeout must be positive")
+	}
+	if alertWebhook != "" {
+		if u, err := url.Parse(alertWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			p.errorf("-alert-webhook must be an http or https URL")
+		}
+		if alertAfter <= 0 || alertDebounce < 0 {
+			p.errorf("-alert-after must be positive and -alert-debounce not negative")
+		}
+	} else if set["alert-after"] || set["alert-debounce"] {
+		p.warnf("-alert-after and -alert-debounce have no effect without -alert-webhook")
+	}
+	if bulkRetries < 0 {
+		p.errorf("-bulk-retries must not be negative")
+	}
+	if maxInflight < 0 {
+		p.errorf("-max-inflight must not be negative")
+	}
+	if retryBudgetRatio < 0 {
+		p.errorf("-retry-budget must not be negative")
+	}
+	if breakerThreshold > 0 && breakerCooldown <= 0 {
+		p.errorf("-breaker-cooldown must be positive")
+	}
+
+	for _, w := range p.warnings {
+		fmt.Printf("///- Warning: %s\n", w)
+	}
+	for _, e := range p.errors {
+		fmt.Printf("///- Error: %s\n", e)
+	}
+	if len(p.errors) > 0 {
+		fmt.Printf("///- Preflight failed with %d error(s)\n", len(p.errors))
+		os.Exit(1)
+	}
+}
+
+// sameFile reports whether paths a and b name the same file.
+func sameFile(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
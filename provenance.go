@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net"
+	"strconv"
+)
+
+// ProvenanceField is the object -include-provenance adds to documents.
+const ProvenanceField = "hpfeeds"
+
+// provenance describes where mes came from: the channel it was subscribed
+// on, the broker by name and address, and the ident of the publisher. The
+// broker's address is left out for replayed messages whose broker isn't
+// configured.
+func provenance(mes message) map[string]interface{} {
+	hp := map[string]interface{}{"channel": mes.Channel}
+	if mes.Broker != "" {
+		hp["broker"] = mes.Broker
+	}
+	for _, b := range brokers {
+		if b.name == mes.Broker {
+			hp["broker_host"] = net.JoinHostPort(b.host, strconv.Itoa(b.port))
+			break
+		}
+	}
+	if mes.Name != "" {
+		hp["ident"] = mes.Name
+	}
+	return hp
+}
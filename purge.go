@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// purgeOldDocuments deletes documents whose -timestamp-field is older than
+// maxAge from each app index. Unless execute is set it only counts what
+// would be deleted, so the default is always a dry run.
+func purgeOldDocuments(client *elastic.Client, maxAge time.Duration, execute bool) {
+	cutoff := time.Now().UTC().Add(-maxAge)
+	query := elastic.NewRangeQuery(timestampField).Lt(cutoff.Format(time.RFC3339))
+
+	if execute {
+		fmt.Printf("Deleting documents with %s before %s\n", timestampField, cutoff.Format(time.RFC3339))
+	} else {
+		fmt.Printf("Dry run: counting documents with %s before %s (use -confirm to delete)\n", timestampField, cutoff.Format(time.RFC3339))
+	}
+
+	ctx := context.Background()
+	var total int64
+	for _, app := range indexKeys() {
+		index := appIndexPattern(app)
+
+		if !execute {
+			n, err := client.Count(index).Query(query).IgnoreUnavailable(true).Do(ctx)
+			if err != nil {
+				log.Printf("%s: %v\n", index, err)
+				continue
+			}
+			fmt.Printf("%s: would delete %d documents\n", index, n)
+			total += n
+			continue
+		}
+
+		res, err := client.DeleteByQuery(index).Query(query).IgnoreUnavailable(true).Do(ctx)
+		if err != nil {
+			log.Printf("%s: %v\n", index, err)
+			continue
+		}
+		fmt.Printf("%s: deleted %d documents\n", index, res.Deleted)
+		if len(res.Failures) > 0 {
+			log.Printf("%s: %d failures, first: %#v\n", index, len(res.Failures), res.Failures[0])
+		}
+		total += res.Deleted
+	}
+
+	if execute {
+		fmt.Printf("Deleted %d documents in total\n", total)
+	} else {
+		fmt.Printf("Would delete %d documents in total\n", total)
+	}
+}
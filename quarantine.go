@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// quarantineMapping maps the handful of fields quarantined documents have,
+// with dynamic mapping off, so nothing in a broken payload can cause a
+// mapping conflict on the way in.
+const quarantineMapping = `{
+	"mappings": {
+		"dynamic": false,
+		"properties": {
+			"raw": {"type": "text"},
+			"error": {"type": "text"},
+			"channel": {"type": "keyword"},
+			"received_at": {"type": "date"}
+		}
+	}
+}`
+
+// ensureQuarantineIndex creates the quarantine index if it doesn't exist.
+func ensureQuarantineIndex(client *elastic.Client, index string) {
+	ctx := context.Background()
+	exists, err := client.IndexExists(index).Do(ctx)
+	if err != nil {
+		log.Printf("Error checking quarantine index: %v\n", err)
+		return
+	}
+	if exists {
+		return
+	}
+	if _, err := client.CreateIndex(index).Body(quarantineMapping).Do(ctx); err != nil {
+		log.Printf("Error creating quarantine index: %v\n", err)
+	}
+}
+
+// quarantineRequest wraps an unparseable payload in a document for the
+// quarantine index so broken feeds can be analysed instead of discarded.
+// received_at is when the message arrived, which for a replayed capture is
+// when it was recorded; messages that don't know fall back to now.
+func quarantineRequest(mes message, parseErr error) elastic.BulkableRequest {
+	received := mes.Received
+	if received.IsZero() {
+		received = time.Now()
+	}
+	doc := map[string]interface{}{
+		"raw":         string(mes.Payload),
+		"error":       parseErr.Error(),
+		"channel":     mes.Channel,
+		"received_at": received.UTC().Format(time.RFC3339),
+	}
+	return newBulkIndexRequest().Index(quarantineIndex).Doc(doc)
+}
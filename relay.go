@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/d1str0/hpfeeds-elastic/internal/hpfeeds"
+)
+
+// PublishQueueSize is how many enriched documents can wait to be published
+// before new ones are dropped.
+const PublishQueueSize = 1000
+
+// publishQueue holds enriched documents waiting to be published back to the
+// broker on -publish-channel.
+var publishQueue = make(chan []byte, PublishQueueSize)
+
+// queuePublish queues doc for publishing. It never blocks: if the broker
+// connection is down or slow and the queue is full, the document is dropped
+// and counted so the ES pipeline keeps moving.
+func queuePublish(doc map[string]interface{}) {
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		publishDropped.Add(1)
+		return
+	}
+	select {
+	case publishQueue <- buf:
+	default:
+		publishDropped.Add(1)
+	}
+}
+
+// startPublishing feeds the publish queue to hp for the life of one
+// connection. The hpfeeds client stops publishing once its Go channel is
+// closed, which happens when done is closed on disconnect.
+func startPublishing(hp *hpfeeds.Client, channel string, done chan struct{}) {
+	pub := make(chan []byte)
+	hp.Publish(channel, pub)
+	go func() {
+		defer close(pub)
+		for {
+			select {
+			case <-done:
+				return
+			case buf := <-publishQueue:
+				select {
+				case pub <- buf:
+					published.Add(1)
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// renameRule renames the top level field from to to.
+type renameRule struct {
+	from, to string
+}
+
+// renamer maps honeypot specific field names to canonical ones. Rules are
+// applied in order, which is also their precedence: when two fields would
+// be renamed to the same name, the earlier rule wins, and a field that
+// already has the canonical name wins over both. Losing fields are left
+// under their original names.
+type renamer []renameRule
+
+// parseRenames builds the rules from the -rename list of from=to pairs,
+// followed by those from -rename-file, a JSON object of from to to, in
+// order of the from name.
+func parseRenames(list, path string) (renamer, error) {
+	var r renamer
+	for _, pair := range strings.Split(list, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		from, to, ok := splitKeyValue(pair)
+		if !ok || to == "" {
+			return nil, fmt.Errorf("invalid rename %q, want from=to", pair)
+		}
+		r = append(r, renameRule{from, to})
+	}
+
+	if path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var file map[string]string
+		if err := json.Unmarshal(buf, &file); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		var froms []string
+		for from := range file {
+			froms = append(froms, from)
+		}
+		sort.Strings(froms)
+		for _, from := range froms {
+			if file[from] == "" {
+				return nil, fmt.Errorf("%s: empty name to rename %q to", path, from)
+			}
+			r = append(r, renameRule{from, file[from]})
+		}
+	}
+	return r, nil
+}
+
+// collisions describes the rules that rename several fields to the same
+// name, for warning about at startup.
+func (r renamer) collisions() []string {
+	var out []string
+	first := make(map[string]string)
+	for _, rule := range r {
+		if winner, ok := first[rule.to]; ok {
+			out = append(out, fmt.Sprintf("%s and %s both rename to %s, %s takes precedence", winner, rule.from, rule.to, winner))
+			continue
+		}
+		first[rule.to] = rule.from
+	}
+	return out
+}
+
+// Apply renames the fields of doc and reports whether anything changed.
+func (r renamer) Apply(doc map[string]interface{}) bool {
+	changed := false
+	for _, rule := range r {
+		v, ok := doc[rule.from]
+		if !ok {
+			continue
+		}
+		if _, taken := doc[rule.to]; taken {
+			renameCollisions.Add(rule.to, 1)
+			parseLog.Printf("Not renaming %s to %s, which is already set\n", rule.from, rule.to)
+			continue
+		}
+		doc[rule.to] = v
+		delete(doc, rule.from)
+		changed = true
+	}
+	return changed
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// renderMappings writes to w, as one JSON object, what the ingester would
+// send ES for the mapping file, rendered for each app, without touching the
+// cluster. Under "indexes" is the body of every index it creates with the
+// mapping, keyed by the name it's created under, and under "template" the
+// index template -init installs, if any. Templated index names aren't known
+// up front, so with -index-template there are no indexes.
+func renderMappings(w io.Writer, mappingFile string) error {
+	out := make(map[string]interface{})
+	if indexTemplate == "" {
+		apps := make(map[string]string) // Index to app.
+		var names []string
+		for _, key := range indexKeys() {
+			name := currentIndex(indexPrefix + key)
+			apps[name] = key
+			names = append(names, name)
+		}
+		names = append(names, sharedIndexNames()...)
+
+		indexes := make(map[string]interface{})
+		for _, name := range names {
+			buf, err := renderMappingFile(mappingFile, apps[name], name)
+			if err != nil {
+				return err
+			}
+			index, body, err := indexBody(name, buf)
+			if err != nil {
+				return err
+			}
+			indexes[index] = body
+		}
+		out["indexes"] = indexes
+	}
+	if usesIndexTemplate() {
+		buf, err := readMappingFile(mappingFile)
+		if err != nil {
+			return err
+		}
+		body, err := templateBody(buf)
+		if err != nil {
+			return err
+		}
+		out["template"] = map[string]interface{}{TemplateName: body}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// FirstBackingSuffix names the first backing index of a -write-alias, e.g.
+// mhn-community-data-cowrie-000001. The rollover API counts up from there.
+const FirstBackingSuffix = "-000001"
+
+// validByteSize matches ES byte size values such as 50gb.
+var validByteSize = regexp.MustCompile(`^[0-9]+(b|kb|mb|gb|tb|pb)$`)
+
+// createTargetIndex creates the index documents for name are written to,
+// with the given mapping file contents: name itself, or with -write-alias
+// the first backing index of the write alias name.
+func createTargetIndex(ctx context.Context, client *elastic.Client, name string, mapping []byte) (*elastic.IndicesCreateResult, error) {
+	index, body, err := indexBody(name, mapping)
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateIndex(index).BodyJson(body).Do(ctx)
+}
+
+// indexBody returns the index createTargetIndex creates for name, and the
+// request body it creates it with.
+func indexBody(name string, mapping []byte) (string, map[string]interface{}, error) {
+	var body map[string]interface{}
+	if err := json.Unmarshal(mapping, &body); err != nil {
+		return "", nil, err
+	}
+	if !writeAlias {
+		return name, body, nil
+	}
+	body["aliases"] = map[string]interface{}{
+		name: map[string]interface{}{"is_write_index": true},
+	}
+	return name + FirstBackingSuffix, body, nil
+}
+
+// rolloverEnabled reports whether any -rollover-max-* condition is set.
+func rolloverEnabled() bool {
+	return rolloverMaxAge != 0 || rolloverMaxSize != "" || rolloverMaxDocs != 0
+}
+
+// rolloverAliases asks ES to roll every write alias over each interval,
+// for clusters without ILM. ES only rolls an alias over if one of the
+// -rollover-max-* conditions is met. It returns on shutdown.
+func rolloverAliases(client *elastic.Client, interval time.Duration) {
+	for !sleepOrShutdown(interval) {
+		aliases := []string{}
+		for _, key := range indexKeys() {
+			aliases = append(aliases, currentIndex(indexPrefix+key))
+		}
+		aliases = append(aliases, sharedIndexNames()...)
+		for _, alias := range aliases {
+			rolloverAlias(client, alias)
+		}
+	}
+}
+
+// rolloverAlias rolls alias over if it meets a condition. Aliases that
+// don't exist, such as those of apps nothing was written for, are skipped.
+func rolloverAlias(client *elastic.Client, alias string) {
+	req := client.RolloverIndex(alias)
+	if rolloverMaxAge > 0 {
+		req = req.AddMaxIndexAgeCondition(fmt.Sprintf("%ds", int64(rolloverMaxAge.Seconds())))
+	}
+	if rolloverMaxSize != "" {
+		req = req.AddCondition("max_size", rolloverMaxSize)
+	}
+	if rolloverMaxDocs > 0 {
+		req = req.AddMaxIndexDocsCondition(rolloverMaxDocs)
+	}
+
+	res, err := req.Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		rolloverErrors.Add(1)
+		log.Printf("Error rolling over %s: %v\n", alias, err)
+		return
+	}
+	if !res.RolledOver {
+		return
+	}
+
+	rollovers.Add(alias, 1)
+	var met []string
+	for cond, ok := range res.Conditions {
+		if ok {
+			met = append(met, cond)
+		}
+	}
+	log.Printf("Rolled %s over from %s to %s, conditions met: %v\n", alias, res.OldIndex, res.NewIndex, met)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// shutdownCtx is cancelled when a shutdown signal arrives. Everything
+// feeding messages into processPayloads stops on it, as do the background
+// goroutines.
+var shutdownCtx, startShutdown = context.WithCancel(context.Background())
+
+// shuttingDown is closed when shutdown starts.
+var shuttingDown = shutdownCtx.Done()
+
+// inputs tracks the goroutines that send on the messages channel, which may
+// only be closed once all of them are gone. background tracks the rest of
+// the long running goroutines, which are waited for before exiting.
+var inputs, background sync.WaitGroup
+
+// goBackground runs f in a goroutine tracked by background.
+func goBackground(f func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		f()
+	}()
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM, or until shutdown is
+// started from within, then drains: inputs stop,
+// messages is closed so processPayloads flushes what it has buffered, and
+// drained is waited on for at most timeout before the process exits anyway.
+// Then files and the ES clients are closed and the background goroutines
+// waited for, so nothing is left running. A second signal exits immediately.
+func waitForShutdown(messages chan message, drained chan struct{}, timeout time.Duration, clients ...*elastic.Client) {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case s := <-sig:
+		log.Printf("Received %s, draining for up to %s\n", s, timeout)
+		startShutdown()
+	case <-shuttingDown:
+		log.Printf("Shutting down, draining for up to %s\n", timeout)
+	}
+
+	go func() {
+		s := <-sig
+		log.Printf("Received %s again, exiting without draining\n", s)
+		os.Exit(1)
+	}()
+
+	go func() {
+		inputs.Wait()
+		close(messages)
+	}()
+
+	deadline := time.After(timeout)
+	select {
+	case <-drained:
+	case <-deadline:
+		log.Printf("Drain timed out after %s, exiting with %d documents unflushed\n", timeout, unflushedDocs())
+		os.Exit(1)
+	}
+
+	if rawArchive != nil {
+		rawArchive.Close()
+	}
+	closeDeadLetter()
+	if capture != nil {
+		if err := capture.Close(); err != nil {
+			log.Printf("Error closing capture file: %v\n", err)
+		}
+	}
+	for _, client := range clients {
+		client.Stop()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-deadline:
+		log.Printf("Timed out after %s waiting for background tasks, exiting\n", timeout)
+		os.Exit(1)
+	}
+	log.Println("Drained, exiting")
+}
+
+// sleepOrShutdown sleeps for d and reports false, or returns true early if a
+// shutdown starts in the meantime.
+func sleepOrShutdown(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return false
+	case <-shuttingDown:
+		return true
+	}
+}
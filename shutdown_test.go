@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+// withShutdown gives the test a shutdown of its own, restoring the package's
+// once it's done.
+func withShutdown(t *testing.T) {
+	t.Helper()
+	oldCtx, oldStart, oldDone := shutdownCtx, startShutdown, shuttingDown
+	t.Cleanup(func() {
+		shutdownCtx, startShutdown, shuttingDown = oldCtx, oldStart, oldDone
+	})
+	shutdownCtx, startShutdown = context.WithCancel(context.Background())
+	shuttingDown = shutdownCtx.Done()
+}
+
+// hpfeedsFrame returns an hpfeeds message with opcode and the given
+// length prefixed fields, followed by payload.
+func hpfeedsFrame(opcode byte, fields []string, payload string) []byte {
+	var data []byte
+	for _, f := range fields {
+		data = append(append(data, byte(len(f))), f...)
+	}
+	data = append(data, payload...)
+	hdr := make([]byte, 5)
+	binary.BigEndian.PutUint32(hdr, uint32(len(hdr)+len(data)))
+	hdr[4] = opcode
+	return append(hdr, data...)
+}
+
+// fakeBroker accepts hpfeeds connections on a local port, sends each the
+// auth challenge followed by msgs and then reads until the client hangs up.
+func fakeBroker(t *testing.T, msgs ...[]byte) (*net.TCPAddr, func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(hpfeedsFrame(1, []string{"fake"}, "0123")) // OpInfo
+			for _, m := range msgs {
+				conn.Write(m)
+			}
+			buf := make([]byte, 1024)
+			for {
+				if _, err := conn.Read(buf); err != nil {
+					break
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr), func() {
+		ln.Close()
+		<-done
+	}
+}
+
+func TestShutdownLeavesNoGoroutines(t *testing.T) {
+	withShutdown(t)
+	// os/signal keeps one goroutine for the life of the process once
+	// anything is notified.
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent(),
+		goleak.IgnoreTopFunction("os/signal.signal_recv"),
+		goleak.IgnoreTopFunction("os/signal.loop"))
+
+	addr, stopBroker := fakeBroker(t)
+	defer stopBroker()
+
+	out := make(chan message)
+	goBackground(handleSIGHUP)
+	goBackground(handleSIGUSR1)
+	goBackground(func() { serveMetrics(shutdownCtx, "127.0.0.1:0") })
+	goBackground(func() { sleepOrShutdown(time.Hour) })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs.Add(1)
+	go func() {
+		defer inputs.Done()
+		serveListener(ln, out)
+	}()
+	// An idle client connection must not hold the listener open.
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	b := &broker{
+		name:     "fake",
+		host:     addr.IP.String(),
+		port:     addr.Port,
+		ident:    "ident",
+		auth:     "secret",
+		channels: []string{"cowrie.sessions"},
+		state:    newConnState("fake", []string{"cowrie.sessions"}),
+	}
+	inputs.Add(1)
+	go func() {
+		defer inputs.Done()
+		b.run(out, false)
+	}()
+	for !b.state.IsConnected() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	startShutdown()
+	stopped := make(chan struct{})
+	go func() {
+		inputs.Wait()
+		background.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("goroutines still running 10s after shutdown")
+	}
+}
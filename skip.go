@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+)
+
+// Reasons a message is skipped rather than indexed, as counted in the
+// skipped_total metric.
+const (
+	SkipMaxMessages       = "max_messages"
+	SkipUnexpectedChannel = "unexpected_channel"
+	SkipParseError        = "parse_error"
+	SkipStale             = "stale"
+	SkipTransformError    = "transform_error"
+	SkipFiltered          = "filtered"
+	SkipPaused            = "paused"
+)
+
+// skipMessage counts mes, of app if known, as skipped for reason, and with
+// -log-skipped logs it as key=value pairs.
+func skipMessage(reason, app string, mes message) {
+	skippedTotal.Add(reason, 1)
+	if logSkipped {
+		log.Printf("skipped reason=%s app=%q channel=%q broker=%q bytes=%d\n",
+			reason, app, mes.Channel, mes.Broker, len(mes.Payload))
+	}
+}
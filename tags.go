@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// parseTags parses -tag key=value arguments.
+func parseTags(args []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, arg := range args {
+		k, v, ok := splitKeyValue(arg)
+		if !ok {
+			return nil, fmt.Errorf("invalid tag %q, want key=value", arg)
+		}
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+// addTags merges the static tags into doc. When the payload already has a
+// field of the same name, the tag replaces it unless precedence (from
+// -tag-precedence) is payload; either way the collision is logged and
+// counted.
+func addTags(doc map[string]interface{}, tags map[string]string, precedence string) {
+	for k, v := range tags {
+		if _, ok := doc[k]; ok {
+			tagCollisions.Add(k, 1)
+			parseLog.Printf("Tag %s collides with a payload field, %s wins\n", k, precedence)
+			if precedence == "payload" {
+				continue
+			}
+		}
+		doc[k] = v
+	}
+}
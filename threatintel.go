@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// threatIntel tags documents whose src_ip matches a local list of known-bad
+// IPs or CIDRs. It implements Processor so it runs in the same chain as
+// external plugins.
+type threatIntel struct {
+	mu    sync.RWMutex
+	path  string
+	ips   map[string]string // IP -> list name
+	cidrs []threatNet
+}
+
+type threatNet struct {
+	net  *net.IPNet
+	list string
+}
+
+// newThreatIntel loads the indicators found at path, which is either a
+// single list file or a directory of list files.
+func newThreatIntel(path string) (*threatIntel, error) {
+	t := &threatIntel{path: path}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload re-reads the indicator lists from disk and swaps them in. On error
+// the previously loaded lists are kept.
+func (t *threatIntel) Reload() error {
+	t.mu.RLock()
+	path := t.path
+	t.mu.RUnlock()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	files := []string{path}
+	if fi.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+
+	ips := make(map[string]string)
+	var cidrs []threatNet
+	for _, file := range files {
+		list := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if err := readIndicators(file, list, ips, &cidrs); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	t.ips = ips
+	t.cidrs = cidrs
+	t.mu.Unlock()
+
+	log.Printf("Loaded %d threat intel indicators from %s\n", len(ips)+len(cidrs), path)
+	return nil
+}
+
+// SetPath changes where indicators are loaded from on the next Reload.
+func (t *threatIntel) SetPath(path string) {
+	t.mu.Lock()
+	t.path = path
+	t.mu.Unlock()
+}
+
+// readIndicators parses one IP or CIDR per line. Blank lines and lines
+// starting with # are ignored.
+func readIndicators(file, list string, ips map[string]string, cidrs *[]threatNet) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.Contains(text, "/") {
+			_, n, err := net.ParseCIDR(text)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", file, line, err)
+			}
+			*cidrs = append(*cidrs, threatNet{n, list})
+			continue
+		}
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return fmt.Errorf("%s:%d: invalid IP %q", file, line, text)
+		}
+		ips[ip.String()] = list
+	}
+	return scanner.Err()
+}
+
+// lookup returns the name of the list containing ip, if any.
+func (t *threatIntel) lookup(ip net.IP) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if list, ok := t.ips[ip.String()]; ok {
+		return list, true
+	}
+	for _, c := range t.cidrs {
+		if c.net.Contains(ip) {
+			return c.list, true
+		}
+	}
+	return "", false
+}
+
+// Process tags the document with threat.matched and threat.list when its
+// src_ip is a known indicator.
+func (t *threatIntel) Process(doc map[string]interface{}) (map[string]interface{}, error) {
+	s, ok := doc["src_ip"].(string)
+	if !ok {
+		return doc, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return doc, nil
+	}
+	if list, ok := t.lookup(ip); ok {
+		threatMatches.Add(1)
+		doc["threat"] = map[string]interface{}{
+			"matched": true,
+			"list":    list,
+		}
+	}
+	return doc, nil
+}
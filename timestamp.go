@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventTimeFields are the payload fields checked, in order, for the time an
+// event actually happened on the honeypot. -timestamp-fields replaces them.
+var eventTimeFields = []string{"timestamp", "@timestamp", "time", "start_time"}
+
+// Sources of the document timestamp for -timestamp-sources: the event time
+// in the payload, when the message arrived from the broker (or was recorded,
+// for a replay), and the time it's processed.
+const (
+	TimestampPayload  = "payload"
+	TimestampReceived = "received"
+	TimestampIngest   = "ingest"
+)
+
+// parseTimestampFields parses the comma separated -timestamp-fields list.
+func parseTimestampFields(list string) []string {
+	var fields []string
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// parseTimestampSources parses the comma separated -timestamp-sources list.
+func parseTimestampSources(list string) ([]string, error) {
+	var sources []string
+	for _, s := range strings.Split(list, ",") {
+		switch s = strings.TrimSpace(s); s {
+		case TimestampPayload, TimestampReceived, TimestampIngest:
+			sources = append(sources, s)
+		default:
+			return nil, fmt.Errorf("unknown timestamp source %q, want payload, received or ingest", s)
+		}
+	}
+	return sources, nil
+}
+
+// docTimestamp returns the time from the first of sources that has one.
+// payload is the event time from the payload, if hasPayload, and received
+// is zero when unknown. Ingest time is the last resort whatever the list.
+func docTimestamp(sources []string, payload time.Time, hasPayload bool, received time.Time) time.Time {
+	for _, source := range sources {
+		switch source {
+		case TimestampPayload:
+			if hasPayload {
+				return payload
+			}
+		case TimestampReceived:
+			if !received.IsZero() {
+				return received
+			}
+		case TimestampIngest:
+			return time.Now()
+		}
+	}
+	return time.Now()
+}
+
+// eventTimeLayouts are the string formats honeypots are known to use.
+// Layouts without a zone are interpreted as UTC.
+var eventTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// eventTime returns the event time carried in the payload, if any field
+// holds a value we can parse.
+func eventTime(doc map[string]interface{}) (time.Time, bool) {
+	for _, field := range eventTimeFields {
+		v, ok := doc[field]
+		if !ok {
+			continue
+		}
+		if t, ok := parseEventTime(v); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseEventTime parses a timestamp given either as a string in one of the
+// known layouts or as a number of seconds since the epoch.
+func parseEventTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case string:
+		s := strings.TrimSpace(v)
+		for _, layout := range eventTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return epochTime(f), true
+		}
+	case float64:
+		return epochTime(v), true
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return epochTime(f), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// epochTime converts seconds since the epoch, possibly fractional, to a
+// time. Values too large to be seconds are treated as milliseconds.
+func epochTime(f float64) time.Time {
+	if f > 1e11 {
+		f /= 1000
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)).UTC()
+}
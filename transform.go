@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// compileTransform compiles the -transform jq program. A program starting
+// with @ is read from the file named by the rest.
+func compileTransform(program string) (*gojq.Code, error) {
+	if strings.HasPrefix(program, "@") {
+		buf, err := ioutil.ReadFile(program[1:])
+		if err != nil {
+			return nil, err
+		}
+		program = string(buf)
+	}
+	query, err := gojq.Parse(program)
+	if err != nil {
+		return nil, err
+	}
+	return gojq.Compile(query)
+}
+
+// applyTransform runs the transform on doc and returns the document it
+// produces. Only the first output is used. A program that outputs null or
+// nothing drops the document, which is reported as a nil document and no
+// error.
+func applyTransform(code *gojq.Code, doc map[string]interface{}) (map[string]interface{}, error) {
+	iter := code.Run(doc)
+	v, ok := iter.Next()
+	if !ok || v == nil {
+		return nil, nil
+	}
+	if err, ok := v.(error); ok {
+		return nil, err
+	}
+	out, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("transform produced %T, want an object", v)
+	}
+	return out, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// fieldMismatch counts the sampled documents whose value for a field
+// doesn't fit its mapped type, with one example value.
+type fieldMismatch struct {
+	typ     string
+	count   int
+	example interface{}
+}
+
+// validateDocuments samples up to sample random documents from each app
+// index and checks their fields against the types in the mapping file,
+// using the same rules as -coerce-to-mapping. It only reads, and reports
+// whether every sampled document would still be accepted.
+func validateDocuments(client *elastic.Client, mappingFile string, sample int) (bool, error) {
+	props, err := readMappingProperties(mappingFile)
+	if err != nil {
+		return false, err
+	}
+	types := mappingTypes(props, "", nil)
+
+	ctx := context.Background()
+	query := elastic.NewFunctionScoreQuery().AddScoreFunc(elastic.NewRandomFunction())
+	ok := true
+	for _, app := range indexKeys() {
+		index := appIndexPattern(app)
+		res, err := client.Search(index).
+			Query(query).
+			Size(sample).
+			IgnoreUnavailable(true).
+			AllowNoIndices(true).
+			Do(ctx)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", index, err)
+		}
+		if len(res.Hits.Hits) == 0 {
+			continue
+		}
+
+		mismatches := make(map[string]*fieldMismatch)
+		for _, hit := range res.Hits.Hits {
+			var doc map[string]interface{}
+			if err := json.Unmarshal(hit.Source, &doc); err != nil {
+				continue
+			}
+			checkDoc(doc, types, "", mismatches)
+		}
+
+		if len(mismatches) == 0 {
+			fmt.Printf("%s: %d documents sampled, all valid\n", index, len(res.Hits.Hits))
+			continue
+		}
+		ok = false
+		fmt.Printf("%s: %d documents sampled, mismatches:\n", index, len(res.Hits.Hits))
+		var fields []string
+		for field := range mismatches {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			m := mismatches[field]
+			fmt.Printf("  %s (%s): %d documents, e.g. %v\n", field, m.typ, m.count, m.example)
+		}
+	}
+	return ok, nil
+}
+
+// checkDoc records in mismatches the fields of doc whose values can't be
+// coerced to their mapped type. It follows the same paths as coerceDoc.
+func checkDoc(doc map[string]interface{}, types fieldTypes, prefix string, mismatches map[string]*fieldMismatch) {
+	for k, v := range doc {
+		path := prefix + k
+		if nested, ok := v.(map[string]interface{}); ok {
+			if _, mapped := types[path]; !mapped {
+				checkDoc(nested, types, path+".", mismatches)
+				continue
+			}
+		}
+		t, ok := types[path]
+		if !ok {
+			continue
+		}
+
+		values := []interface{}{v}
+		if list, ok := v.([]interface{}); ok {
+			values = list
+		}
+		for _, e := range values {
+			if _, ok := coerceValue(e, t); ok {
+				continue
+			}
+			m := mismatches[path]
+			if m == nil {
+				m = &fieldMismatch{typ: t, example: e}
+				mismatches[path] = m
+			}
+			m.count++
+			break
+		}
+	}
+}
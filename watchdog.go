@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// touch records that a message was just received from the broker.
+func (b *broker) touch() {
+	atomic.StoreInt64(&b.lastMessage, time.Now().UnixNano())
+}
+
+// sinceLastMessage returns how long ago the last message was received from
+// the broker.
+func (b *broker) sinceLastMessage() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&b.lastMessage)))
+}
+
+// requestReconnect asks run to drop the connection and reconnect, giving err
+// as the reason. The watchdogs don't close the connection themselves: the
+// hpfeeds client isn't safe to close from two goroutines, so only run
+// disconnects it.
+func requestReconnect(stalled chan error, err error) {
+	select {
+	case stalled <- err:
+	default:
+	}
+}
+
+// watchIdle asks for a reconnect if no message has been received for longer
+// than timeout. A half-open TCP connection never signals Disconnected on its
+// own, so this is what lets the reconnect loop recover from a silent stall.
+// The watchdog exits when done is closed.
+func (b *broker) watchIdle(stalled chan error, timeout time.Duration, done chan struct{}) {
+	interval := timeout / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if idle := b.sinceLastMessage(); idle > timeout {
+				log.Printf("No messages received from %s in %s, forcing reconnect\n", b.name, idle.Round(time.Second))
+				idleReconnects.Add(1)
+				requestReconnect(stalled, fmt.Errorf("no messages in %s", idle.Round(time.Second)))
+				return
+			}
+		}
+	}
+}
+
+// watchSubscriptions checks timeout after subscribing which channels have
+// delivered anything. The others are logged as possibly refused, since the
+// broker answers a subscribe it doesn't allow only with an error the client
+// library logs. If none has delivered, the connection is treated as failed
+// and a reconnect requested.
+func (b *broker) watchSubscriptions(stalled chan error, timeout time.Duration, done chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	pending := b.state.Unconfirm()
+	if len(pending) == 0 {
+		return
+	}
+	if len(pending) < len(b.channels) {
+		log.Printf("No messages from %s on %s within %s of subscribing, the subscribe may have been refused\n",
+			b.name, strings.Join(pending, ", "), timeout)
+		return
+	}
+	log.Printf("No messages from %s on any channel within %s of subscribing, check the ident's permissions; reconnecting\n",
+		b.name, timeout)
+	requestReconnect(stalled, errors.New("no channel delivered after subscribing"))
+}
+
+// startupWatch starts the -startup-message-timeout countdown the first time
+// any broker subscribes.
+var startupWatch sync.Once
+
+// watchStartup exits the process if no message at all has arrived timeout
+// after the first subscribe, so a connection that authenticates but never
+// delivers, e.g. from a wrong channel name, fails loudly.
+func watchStartup(timeout time.Duration) {
+	if sleepOrShutdown(timeout) {
+		return
+	}
+	if messagesReceived.Value() == 0 {
+		log.Fatalf("No messages received within %s of subscribing, exiting", timeout)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchIdleRequestsReconnect(t *testing.T) {
+	b := &broker{name: "test"}
+	stalled := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go b.watchIdle(stalled, time.Millisecond, done)
+	select {
+	case err := <-stalled:
+		if err == nil {
+			t.Error("reconnect requested without a reason")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reconnect requested")
+	}
+}
+
+func TestRequestReconnectDoesNotBlock(t *testing.T) {
+	stalled := make(chan error, 1)
+	requestReconnect(stalled, nil)
+	// Both watchdogs may fire for the same connection; the second request
+	// is dropped rather than blocking.
+	requestReconnect(stalled, nil)
+	if len(stalled) != 1 {
+		t.Errorf("%d requests queued, want 1", len(stalled))
+	}
+}